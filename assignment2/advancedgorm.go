@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
+	"assignment2/config"
+	"assignment2/txn"
+
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -27,13 +31,17 @@ type Profile struct {
 }
 
 // Connect to MySQL using GORM
-func ConnectGORM() {
+func ConnectGORM(cfg config.DatabaseConfig) {
 	var err error
-	dsn := "root:password@tcp(127.0.0.1:3306)/gocon?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	db, err = gorm.Open(mysql.Open(cfg.DSN()), &gorm.Config{})
 	if err != nil {
 		log.Fatal("Failed to connect to MySQL database:", err)
 	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal("Failed to get the connection pool:", err)
+	}
+	cfg.ApplyPool(sqlDB)
 	fmt.Println("Connected to MySQL using GORM!")
 }
 
@@ -44,11 +52,15 @@ func AutoMigrateModels() {
 }
 
 // Insert user and profile with transaction
-func InsertUserWithProfile() {
-	user := User{Name: "John Doe", Age: 28, Profile: Profile{Bio: "Software Engineer", ProfilePictureURL: "https://cdn.pixabay.com/photo/2015/10/05/22/37/blank-profile-picture-973460_960_720.png"}}
-	result := db.Create(&user)
-	if result.Error != nil {
-		fmt.Println("Failed to insert user:", result.Error)
+func InsertUserWithProfile(ctx context.Context) {
+	err := txn.WithGORMTx(ctx, db, nil, func(tx *gorm.DB) error {
+		// Built afresh for each attempt, so that a retry does not reuse the
+		// IDs of a rolled-back one
+		user := User{Name: "John Doe", Age: 28, Profile: Profile{Bio: "Software Engineer", ProfilePictureURL: "https://cdn.pixabay.com/photo/2015/10/05/22/37/blank-profile-picture-973460_960_720.png"}}
+		return tx.Create(&user).Error
+	})
+	if err != nil {
+		fmt.Println("Failed to insert user:", err)
 		return
 	}
 	fmt.Println("User and profile inserted successfully!")
@@ -85,9 +97,11 @@ func DeleteUserWithProfile(userID uint) {
 }
 
 func main() {
-	ConnectGORM()
+	cfg := config.MustLoad()
+
+	ConnectGORM(cfg.Database)
 	AutoMigrateModels()
-	InsertUserWithProfile()
+	InsertUserWithProfile(context.Background())
 	QueryUsersWithProfile()
 
 	fmt.Println("Updating user's profile...")
@@ -1,13 +1,37 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
 
+	"assignment2/apperr"
+	"assignment2/auth"
+	"assignment2/config"
+	"assignment2/deadline"
+	"assignment2/health"
+	"assignment2/loadshed"
+	"assignment2/logging"
+	"assignment2/metrics"
+	"assignment2/models"
+	"assignment2/pagination"
+	"assignment2/policy"
+	"assignment2/problem"
+	"assignment2/querybuilder"
+	"assignment2/ratelimit"
+	"assignment2/repository"
+	"assignment2/schema"
+	"assignment2/server"
+	"assignment2/servertiming"
+	"assignment2/slowquery"
+	"assignment2/tracing"
+	"assignment2/validation"
+
 	_ "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -20,6 +44,9 @@ import (
 var (
 	sqlDB  *sql.DB  // for direct SQL queries
 	gormDB *gorm.DB // for GORM queries
+
+	// Signs list cursors; set cursor_secret so cursors survive restarts
+	cursorCodec *pagination.Codec
 )
 
 // @title           GoLang REST API by Bakytzhan
@@ -34,223 +61,995 @@ var (
 // @host            localhost:8080
 // @BasePath        /
 
-type User struct {
-	ID      uint    `json:"id" gorm:"primaryKey"`
-	Name    string  `json:"name" gorm:"unique;not null"`
-	Age     int     `json:"age" gorm:"not null"`
-	Profile Profile `json:"profile" gorm:"foreignKey:UserID"`
-}
+// @securityDefinitions.apikey BearerAuth
+// @in                         header
+// @name                       Authorization
+// @description                "Bearer " followed by an access token from /auth/login, or by an API key
 
-type Profile struct {
-	ID                uint   `json:"id" gorm:"primaryKey"`
-	UserID            uint   `json:"user_id" gorm:"unique;not null"`
-	Bio               string `json:"bio"`
-	ProfilePictureURL string `json:"profile_picture_url"`
+// @securityDefinitions.apikey ApiKeyAuth
+// @in                         header
+// @name                       X-API-Key
+// @description                An API key from /auth/api-keys
+
+// Opens a MySQL pool whose queries are traced, counted for Server-Timing
+// and checked for slow statements as those of the pool name
+func openPool(cfg config.DatabaseConfig, name string, slow *slowquery.Detector) *sql.DB {
+	connector, err := cfg.Connector()
+	if err != nil {
+		logging.Fatal("Invalid database settings", err)
+	}
+	pool := tracing.OpenDB(servertiming.Connector(slow.Connector(name, connector)))
+	cfg.ApplyPool(pool) // Connection pooling
+	return pool
 }
 
 // Connects to MySQL using sql.DB
-func connectSQL() {
-	var err error
+func connectSQL(cfg config.DatabaseConfig, slow *slowquery.Detector) {
+	sqlDB = openPool(cfg, "sql", slow)
+	slog.Info("Connected to MySQL using sql.DB")
+}
 
-	connStr := "root:password@tcp(127.0.0.1:3306)/gocon"
-	sqlDB, err = sql.Open("mysql", connStr)
+// Connects to MySQL using GORM, with a pool of its own
+func connectGORM(cfg config.DatabaseConfig, slow *slowquery.Detector) {
+	var err error
+	gormDB, err = gorm.Open(mysql.New(mysql.Config{Conn: openPool(cfg, "gorm", slow)}), &gorm.Config{}) // Use gormDB
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		logging.Fatal("Failed to connect to MySQL database", err)
 	}
-	sqlDB.SetMaxOpenConns(25) // Connection pooling
-	sqlDB.SetMaxIdleConns(25)
-	fmt.Println("Connected to MySQL using sql.DB!")
+	if err := errors.Join(gormDB.Use(tracing.GORMPlugin()), gormDB.Use(servertiming.GORMPlugin())); err != nil {
+		logging.Fatal("Failed to set up the GORM plugins", err)
+	}
+	slog.Info("Connected to MySQL using GORM")
 }
 
-// Connects to MySQL using GORM
-func connectGORM() {
-	var err error
-	dsn := "root:password@tcp(127.0.0.1:3306)/gocon?charset=utf8mb4&parseTime=True&loc=Local"
-	gormDB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{}) // Use gormDB
+// userHandlers serves the user endpoints on top of a UserRepository, so the
+// same handlers back both the /sql and /gorm routes
+type userHandlers struct {
+	repo    repository.UserRepository
+	require func(scope string) func(http.Handler) http.Handler // authenticates and checks the scope
+	limit   func(http.Handler) http.Handler                    // rate limits the client, also before it is authenticated
+}
+
+// Registers the user routes under prefix, e.g. "/sql" or "/gorm"
+func (h userHandlers) register(mux *http.ServeMux, prefix string) {
+	route := func(pattern, scope string, handler http.HandlerFunc) {
+		mux.Handle(pattern, h.limit(h.require(scope)(h.limit(handler))))
+	}
+	route("GET "+prefix+"/users", auth.ScopeUsersRead, h.listUsers)
+	route("POST "+prefix+"/users", auth.ScopeUsersWrite, h.createUser)
+	route("GET "+prefix+"/users/{id}", auth.ScopeUsersRead, h.getUser)
+	route("PUT "+prefix+"/users/{id}", auth.ScopeUsersWrite, h.updateUser)
+	route("PATCH "+prefix+"/users/{id}", auth.ScopeUsersWrite, h.patchUser)
+	route("DELETE "+prefix+"/users/{id}", auth.ScopeUsersWrite, h.deleteUser)
+	route("GET "+prefix+"/users/{id}/profile", auth.ScopeProfilesRead, h.getProfile)
+	route("PUT "+prefix+"/users/{id}/profile", auth.ScopeProfilesWrite, h.saveProfile)
+}
+
+// Registers the administration routes
+func (h userHandlers) registerAdmin(mux *http.ServeMux) {
+	mux.Handle("PUT /admin/users/{id}/role", h.limit(h.require(auth.ScopeAdmin)(h.limit(http.HandlerFunc(h.assignRole)))))
+}
+
+// Returns the repository as seen by the caller, enforcing its role and ownership
+func (h userHandlers) repoFor(r *http.Request) repository.UserRepository {
+	caller, _ := auth.FromContext(r.Context())
+	return policy.NewGuard(h.repo, caller)
+}
+
+// authHandlers serves the account endpoints
+type authHandlers struct {
+	svc   *auth.Service
+	users repository.UserRepository // checked by the admin routes
+	limit func(http.Handler) http.Handler
+}
+
+// Registers the account routes under /auth
+func (h authHandlers) register(mux *http.ServeMux) {
+	// Anonymous requests are limited by IP address, and so are the others
+	// until their credentials have been checked
+	public := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, h.limit(handler))
+	}
+	route := func(pattern, scope string, handler http.HandlerFunc) {
+		mux.Handle(pattern, h.limit(h.svc.Require(scope)(h.limit(handler))))
+	}
+	public("POST /auth/register", h.registerUser)
+	public("POST /auth/login", h.login)
+	public("POST /auth/refresh", h.refresh)
+	route("POST /auth/logout", "", h.logout)
+	route("POST /auth/api-keys", "", h.createAPIKey)
+	route("GET /auth/api-keys", "", h.listAPIKeys)
+	route("DELETE /auth/api-keys/{id}", "", h.revokeAPIKey)
+	route("POST /auth/2fa/enroll", "", h.enrollTwoFactor)
+	route("GET /auth/2fa/qr.png", "", h.twoFactorQRCode)
+	route("POST /auth/2fa/confirm", "", h.confirmTwoFactor)
+	route("DELETE /admin/users/{id}/2fa", auth.ScopeAdmin, h.resetTwoFactor)
+}
+
+// Writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Parses the {id} path parameter, writing a 400 response if it is not a valid ID
+func userIDFromPath(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || id == 0 {
+		problem.Write(w, r, apperr.BadRequest("Invalid user ID", err))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Parses the filter, sort and pagination parameters shared by the list endpoints
+func listRequest(r *http.Request) (querybuilder.ListParams, pagination.Request, error) {
+	q := r.URL.Query()
+	params, err := querybuilder.Parse(q)
+	if err != nil {
+		return params, pagination.Request{}, err
+	}
+	pg, err := cursorCodec.Parse(q, params.SortKey())
 	if err != nil {
-		log.Fatal("Failed to connect to MySQL database:", err)
+		return params, pg, err
+	}
+	if pg.Cursor != nil {
+		params.Keyset = &querybuilder.Keyset{Values: pg.Cursor.Values, Backward: pg.Cursor.Backward}
 	}
-	fmt.Println("Connected to MySQL using GORM!")
+	return params, pg, nil
 }
 
-// @Summary Get Users with optional filtering and pagination (SQL)
-// @Description Retrieve a list of users from MySQL using SQL queries with optional filtering by age and pagination.
+// @Summary Get Users with optional filtering and pagination
+// @Description Retrieve a list of users from MySQL, using SQL queries or GORM, with optional filtering by age and name, sorting and pagination. Users without the admin role only see their own account. Requires the users:read scope.
 // @Tags Users
 // @Produce json
-// @Param age query string false "Filter by age"
-// @Param sort query string false "Sort by name (asc or desc)"
-// @Param page query string false "Pagination page number"
-// @Success 200 {array} User
-// @Failure 500 {object} map[string]string
+// @Param age query int false "Filter by exact age"
+// @Param age_min query int false "Minimum age (inclusive)"
+// @Param age_max query int false "Maximum age (inclusive)"
+// @Param name_prefix query string false "Names starting with this value"
+// @Param name_contains query string false "Names containing this value"
+// @Param sort query string false "Comma separated sort keys (id, name, age), prefix with - for descending; asc or desc sorts by name"
+// @Param limit query int false "Page size (default 10, max 100)"
+// @Param cursor query string false "Opaque cursor from X-Next-Cursor or X-Prev-Cursor"
+// @Param page query string false "Pagination page number, ignored when cursor is set"
+// @Param envelope query bool false "Wrap the list in {data, page, per_page, total}"
+// @Success 200 {array} models.User
+// @Header 200 {string} Link "RFC 8288 first, prev, next and last links"
+// @Header 200 {integer} X-Total-Count "Number of users matching the filters"
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page"
+// @Header 200 {string} X-Prev-Cursor "Cursor of the previous page"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /sql/users [get]
-func getUsersSQL(w http.ResponseWriter, r *http.Request) {
-	ageFilter := r.URL.Query().Get("age")
-	sortOrder := r.URL.Query().Get("sort")
-	pageStr := r.URL.Query().Get("page")
-	page := 1
-	if pageStr != "" {
-		var err error
-		page, err = strconv.Atoi(pageStr)
-		if err != nil {
-			http.Error(w, "Invalid page number", http.StatusBadRequest)
-			return
-		}
+// @Router /gorm/users [get]
+func (h userHandlers) listUsers(w http.ResponseWriter, r *http.Request) {
+	params, pg, err := listRequest(r)
+	if err != nil {
+		problem.Write(w, r, apperr.BadRequest("Invalid query", err))
+		return
 	}
 
-	limit := 10
-	offset := (page - 1) * limit
-	query := "SELECT * FROM users"
-	if ageFilter != "" {
-		query += " WHERE age = " + ageFilter
+	repo := h.repoFor(r)
+	users, err := repo.List(r.Context(), params, pg.Limit+1, pg.Offset())
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to retrieve users", err))
+		return
 	}
-	if sortOrder == "asc" {
-		query += " ORDER BY name ASC"
-	} else if sortOrder == "desc" {
-		query += " ORDER BY name DESC"
+	total, err := repo.Count(r.Context(), params)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to count users", err))
+		return
 	}
-	query += " LIMIT ? OFFSET ?"
 
-	rows, err := sqlDB.Query(query, limit, offset)
-	if err != nil {
-		http.Error(w, "Failed to retrieve users: "+err.Error(), http.StatusInternalServerError)
+	page := pagination.Paginate(cursorCodec, pg, users, func(user models.User) []interface{} {
+		return repository.SortValues(params, user)
+	})
+	pagination.SetHeaders(w.Header(), r.URL, pg, page, total)
+	if pagination.WantsEnvelope(r.URL.Query()) {
+		writeJSON(w, http.StatusOK, page.Envelope(pg, total))
 		return
 	}
-	defer rows.Close()
+	writeJSON(w, http.StatusOK, page.Items)
+}
 
-	var users []User
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.Name, &user.Age); err != nil {
-			http.Error(w, "Failed to scan user: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		users = append(users, user)
+// @Summary Create a new User
+// @Description Insert a new user, and its profile if given, into MySQL with name uniqueness validation. Only admins may create users. Requires the users:write scope.
+// @Tags Users
+// @Accept  json
+// @Produce  json
+// @Param user body models.User true "User"
+// @Success 201 {object} models.User
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 413 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /sql/users [post]
+// @Router /gorm/users [post]
+func (h userHandlers) createUser(w http.ResponseWriter, r *http.Request) {
+	var user models.User
+	if err := validation.DecodeJSON(r.Body, &user); err != nil {
+		problem.Write(w, r, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	if err := h.repoFor(r).Create(r.Context(), &user); err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to create user", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// @Summary Get a User by ID
+// @Description Retrieve a single user with its profile. Users without the admin role may only access their own account. Requires the users:read scope.
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.User
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /sql/users/{id} [get]
+// @Router /gorm/users/{id} [get]
+func (h userHandlers) getUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.repoFor(r).Get(r.Context(), id)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to retrieve user", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
 }
 
-// @Summary Create a new User (SQL)
-// @Description Insert a new user into MySQL using SQL queries with name uniqueness validation.
+// @Summary Replace a User
+// @Description Replace the name and age of an existing user. Users without the admin role may only access their own account. Requires the users:write scope.
 // @Tags Users
 // @Accept  json
 // @Produce  json
-// @Param user body User true "User"
-// @Success 201 {object} User
-// @Failure 500 {object} map[string]string
-// @Router /sql/users [post]
-func createUserSQL(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid input: "+err.Error(), http.StatusBadRequest)
+// @Param id path int true "User ID"
+// @Param user body repository.UserReplacement true "Name and age"
+// @Success 200 {object} models.User
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 413 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /sql/users/{id} [put]
+// @Router /gorm/users/{id} [put]
+func (h userHandlers) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromPath(w, r)
+	if !ok {
 		return
 	}
 
-	_, err := sqlDB.Exec("INSERT INTO users (name, age) VALUES (?, ?)", user.Name, user.Age)
+	var input repository.UserReplacement
+	if err := validation.DecodeJSON(r.Body, &input); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	user, err := h.repoFor(r).Update(r.Context(), id, input.Update())
 	if err != nil {
-		http.Error(w, "Failed to create user: "+err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, apperr.Wrap("Failed to update user", err))
 		return
 	}
+	writeJSON(w, http.StatusOK, user)
+}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+// @Summary Partially update a User
+// @Description Update only the supplied fields of an existing user. Users without the admin role may only access their own account. Requires the users:write scope.
+// @Tags Users
+// @Accept  json
+// @Produce  json
+// @Param id path int true "User ID"
+// @Param user body repository.UserUpdate true "Fields to update"
+// @Success 200 {object} models.User
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 413 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /sql/users/{id} [patch]
+// @Router /gorm/users/{id} [patch]
+func (h userHandlers) patchUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var update repository.UserUpdate
+	if err := validation.DecodeJSON(r.Body, &update); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	user, err := h.repoFor(r).Update(r.Context(), id, update)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to update user", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
 }
 
-// @Summary Get Users with optional filtering and pagination (GORM)
-// @Description Retrieve a list of users from MySQL using GORM with optional filtering by age and pagination.
+// @Summary Delete a User
+// @Description Delete a user and its profile. Only admins may delete users. Requires the users:write scope.
 // @Tags Users
+// @Param id path int true "User ID"
+// @Success 204
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /sql/users/{id} [delete]
+// @Router /gorm/users/{id} [delete]
+func (h userHandlers) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repoFor(r).Delete(r.Context(), id); err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to delete user", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Get a User's Profile
+// @Description Retrieve the profile of a user. Users without the admin role may only access their own account. Requires the profiles:read scope.
+// @Tags Profiles
 // @Produce json
-// @Param age query string false "Filter by age"
-// @Param sort query string false "Sort by name (asc or desc)"
-// @Param page query string false "Pagination page number"
-// @Success 200 {array} User
-// @Failure 500 {object} map[string]string
-// @Router /gorm/users [get]
-func getUsersGORM(w http.ResponseWriter, r *http.Request) {
-	ageFilter := r.URL.Query().Get("age")
-	sortOrder := r.URL.Query().Get("sort")
-	pageStr := r.URL.Query().Get("page")
-	page := 1
-	if pageStr != "" {
-		var err error
-		page, err = strconv.Atoi(pageStr)
-		if err != nil {
-			http.Error(w, "Invalid page number", http.StatusBadRequest)
-			return
-		}
+// @Param id path int true "User ID"
+// @Success 200 {object} models.Profile
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /sql/users/{id}/profile [get]
+// @Router /gorm/users/{id}/profile [get]
+func (h userHandlers) getProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromPath(w, r)
+	if !ok {
+		return
 	}
 
-	var users []User
-	query := gormDB.Limit(10).Offset((page - 1) * 10)
-	if ageFilter != "" {
-		query = query.Where("age = ?", ageFilter)
+	profile, err := h.repoFor(r).GetProfile(r.Context(), id)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to retrieve profile", err))
+		return
 	}
-	if sortOrder == "asc" {
-		query = query.Order("name asc")
-	} else if sortOrder == "desc" {
-		query = query.Order("name desc")
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// @Summary Create or replace a User's Profile
+// @Description Create the profile of a user, or replace its bio and picture URL. Users without the admin role may only access their own account. Requires the profiles:write scope.
+// @Tags Profiles
+// @Accept  json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param profile body models.Profile true "Profile"
+// @Success 200 {object} models.Profile
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 413 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /sql/users/{id}/profile [put]
+// @Router /gorm/users/{id}/profile [put]
+func (h userHandlers) saveProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromPath(w, r)
+	if !ok {
+		return
 	}
-	query.Find(&users)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	var input models.Profile
+	if err := validation.DecodeJSON(r.Body, &input); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	profile, err := h.repoFor(r).SaveProfile(r.Context(), id, input)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to save profile", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
 }
 
-// @Summary Create a new User (GORM)
-// @Description Insert a new user into MySQL using GORM with name uniqueness validation.
-// @Tags Users
+// @Summary Register an account
+// @Description Create a user with a password and log it in.
+// @Tags Auth
 // @Accept  json
-// @Produce  json
-// @Param user body User true "User"
-// @Success 201 {object} User
-// @Failure 500 {object} map[string]string
-// @Router /gorm/users [post]
-func createUserGORM(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid input: "+err.Error(), http.StatusBadRequest)
+// @Produce json
+// @Param registration body auth.Registration true "Registration"
+// @Success 201 {object} auth.Session
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 413 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/register [post]
+func (h authHandlers) registerUser(w http.ResponseWriter, r *http.Request) {
+	var reg auth.Registration
+	if err := validation.DecodeJSON(r.Body, &reg); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	session, err := h.svc.Register(r.Context(), reg)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to register", err))
 		return
 	}
+	writeJSON(w, http.StatusCreated, session)
+}
+
+// @Summary Log in
+// @Description Exchange a name and password for an access token and a refresh token. Users with two-factor authentication also send otp, the current code of their authenticator, or one of their recovery codes; without one the login fails with a two_factor_required problem.
+// @Tags Auth
+// @Accept  json
+// @Produce json
+// @Param credentials body auth.Credentials true "Credentials"
+// @Success 200 {object} auth.Session
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/login [post]
+func (h authHandlers) login(w http.ResponseWriter, r *http.Request) {
+	var creds auth.Credentials
+	if err := validation.DecodeJSON(r.Body, &creds); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	session, err := h.svc.Login(r.Context(), creds)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to log in", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
 
-	if err := gormDB.Create(&user).Error; err != nil {
-		http.Error(w, "Failed to create user: "+err.Error(), http.StatusInternalServerError)
+// @Summary Refresh a session
+// @Description Exchange a refresh token for a new access token and refresh token. Each refresh token can be used once.
+// @Tags Auth
+// @Accept  json
+// @Produce json
+// @Param refresh body auth.RefreshRequest true "Refresh token"
+// @Success 200 {object} auth.Session
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/refresh [post]
+func (h authHandlers) refresh(w http.ResponseWriter, r *http.Request) {
+	var req auth.RefreshRequest
+	if err := validation.DecodeJSON(r.Body, &req); err != nil {
+		problem.Write(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	session, err := h.svc.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to refresh session", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// @Summary Log out
+// @Description Revoke the access token and the refresh token of the session.
+// @Tags Auth
+// @Accept  json
+// @Param refresh body auth.RefreshRequest true "Refresh token"
+// @Success 204
+// @Security BearerAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/logout [post]
+func (h authHandlers) logout(w http.ResponseWriter, r *http.Request) {
+	var req auth.RefreshRequest
+	if err := validation.DecodeJSON(r.Body, &req); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	id, _ := auth.FromContext(r.Context())
+	if err := h.svc.Logout(r.Context(), id, req.RefreshToken); err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to log out", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Create an API key
+// @Description Create an API key with the given scopes (users:read, users:write, profiles:read, profiles:write) and optional expiry. The key is only returned in this response.
+// @Tags Auth
+// @Accept  json
+// @Produce json
+// @Param key body auth.APIKeyRequest true "API key"
+// @Success 201 {object} auth.NewAPIKey
+// @Security BearerAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/api-keys [post]
+func (h authHandlers) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req auth.APIKeyRequest
+	if err := validation.DecodeJSON(r.Body, &req); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	id, _ := auth.FromContext(r.Context())
+	key, err := h.svc.CreateAPIKey(r.Context(), id, req)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to create API key", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// @Summary List API keys
+// @Description List the caller's API keys, including revoked and expired ones.
+// @Tags Auth
+// @Produce json
+// @Success 200 {array} models.APIKey
+// @Security BearerAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/api-keys [get]
+func (h authHandlers) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	id, _ := auth.FromContext(r.Context())
+	keys, err := h.svc.ListAPIKeys(r.Context(), id)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to list API keys", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// @Summary Revoke an API key
+// @Description Revoke one of the caller's API keys.
+// @Tags Auth
+// @Param id path int true "API key ID"
+// @Success 204
+// @Security BearerAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/api-keys/{id} [delete]
+func (h authHandlers) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || keyID == 0 {
+		problem.Write(w, r, apperr.BadRequest("Invalid API key ID", err))
+		return
+	}
+
+	id, _ := auth.FromContext(r.Context())
+	if err := h.svc.RevokeAPIKey(r.Context(), id, uint(keyID)); err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to revoke API key", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Enroll in two-factor authentication
+// @Description Start enrolling the caller in TOTP two-factor authentication, replacing an unconfirmed enrollment. Add the secret to an authenticator app, then confirm it with a code.
+// @Tags Auth
+// @Produce json
+// @Success 201 {object} auth.Enrollment
+// @Security BearerAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/2fa/enroll [post]
+func (h authHandlers) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, _ := auth.FromContext(r.Context())
+	enrollment, err := h.svc.EnrollTwoFactor(r.Context(), id)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to enroll in two-factor authentication", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, enrollment)
+}
+
+// @Summary Get the enrollment QR code
+// @Description The provisioning URI of the caller's unconfirmed enrollment as a QR code, for scanning with an authenticator app.
+// @Tags Auth
+// @Produce png
+// @Success 200 {file} binary
+// @Security BearerAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/2fa/qr.png [get]
+func (h authHandlers) twoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	id, _ := auth.FromContext(r.Context())
+	png, err := h.svc.TwoFactorQRCode(r.Context(), id)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to render the QR code", err))
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+// @Summary Confirm two-factor authentication
+// @Description Enable two-factor authentication with the current code of the authenticator. The response lists single-use recovery codes, which are not shown again.
+// @Tags Auth
+// @Accept  json
+// @Produce json
+// @Param code body auth.OneTimeCode true "Code"
+// @Success 200 {object} auth.RecoveryCodes
+// @Security BearerAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /auth/2fa/confirm [post]
+func (h authHandlers) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req auth.OneTimeCode
+	if err := validation.DecodeJSON(r.Body, &req); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	id, _ := auth.FromContext(r.Context())
+	codes, err := h.svc.ConfirmTwoFactor(r.Context(), id, req.Code)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to confirm two-factor authentication", err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, codes)
+}
+
+// @Summary Reset a User's two-factor authentication
+// @Description Remove a user's two-factor enrollment and recovery codes, e.g. after they lost their authenticator. Only admins may reset it. Requires the admin scope, which API keys cannot have.
+// @Tags Admin
+// @Param id path int true "User ID"
+// @Success 204
+// @Security BearerAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /admin/users/{id}/2fa [delete]
+func (h authHandlers) resetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	caller, _ := auth.FromContext(r.Context())
+	if err := policy.NewGuard(h.users, caller).Authorize(r.Context(), policy.ResetTwoFactor, id); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	if err := h.svc.ResetTwoFactor(r.Context(), id); err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to reset two-factor authentication", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Assign a role to a User
+// @Description Give a user the user or admin role. Only admins may assign roles, and not to themselves. Requires the admin scope, which API keys cannot have.
+// @Tags Admin
+// @Accept  json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role body policy.RoleAssignment true "Role"
+// @Success 200 {object} models.User
+// @Security BearerAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /admin/users/{id}/role [put]
+func (h userHandlers) assignRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input policy.RoleAssignment
+	if err := validation.DecodeJSON(r.Body, &input); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	user, err := h.repoFor(r).SetRole(r.Context(), id, input.Role)
+	if err != nil {
+		problem.Write(w, r, apperr.Wrap("Failed to assign role", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// logHandlers lets admins read and change the log level at runtime
+type logHandlers struct {
+	level *slog.LevelVar
+	users repository.UserRepository // checked for the caller's role
+	limit func(http.Handler) http.Handler
+}
+
+// Registers the logging routes under /admin
+func (h logHandlers) register(mux *http.ServeMux, require func(http.Handler) http.Handler) {
+	mux.Handle("GET /admin/log-level", h.limit(require(h.limit(http.HandlerFunc(h.getLevel)))))
+	mux.Handle("PUT /admin/log-level", h.limit(require(h.limit(http.HandlerFunc(h.setLevel)))))
+}
+
+// Checks that the caller may manage logging
+func (h logHandlers) authorize(w http.ResponseWriter, r *http.Request) bool {
+	caller, _ := auth.FromContext(r.Context())
+	if err := policy.NewGuard(h.users, caller).Authorize(r.Context(), policy.SetLogLevel, 0); err != nil {
+		problem.Write(w, r, err)
+		return false
+	}
+	return true
+}
+
+// @Summary Get the log level
+// @Description The level the server currently logs at. Only admins may read it.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} logging.LevelSetting
+// @Security BearerAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /admin/log-level [get]
+func (h logHandlers) getLevel(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, logging.CurrentLevel(h.level))
+}
+
+// @Summary Change the log level
+// @Description Change the level the server logs at (debug, info, warn or error) until it restarts. Only admins may change it.
+// @Tags Admin
+// @Accept  json
+// @Produce json
+// @Param level body logging.LevelSetting true "Level"
+// @Success 200 {object} logging.LevelSetting
+// @Security BearerAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /admin/log-level [put]
+func (h logHandlers) setLevel(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	var input logging.LevelSetting
+	if err := validation.DecodeJSON(r.Body, &input); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	level, err := logging.ParseLevel(input.Level)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	h.level.Set(level)
+	logging.FromContext(r.Context()).Info("Log level changed", "level", input.Level)
+	writeJSON(w, http.StatusOK, logging.CurrentLevel(h.level))
+}
+
+// slowQueryHandlers lets admins read the statistics of the slow-query log
+type slowQueryHandlers struct {
+	slow  *slowquery.Detector
+	users repository.UserRepository // checked for the caller's role
+	limit func(http.Handler) http.Handler
+}
+
+// Registers the slow-query route under /admin
+func (h slowQueryHandlers) register(mux *http.ServeMux, require func(http.Handler) http.Handler) {
+	mux.Handle("GET /admin/slow-queries", h.limit(require(h.limit(http.HandlerFunc(h.getStats)))))
+}
+
+// @Summary Get the query statistics
+// @Description Count, total, mean and maximum time of each statement shape on each pool, with the caller of its last slow statement and the EXPLAIN plan of its first one if recorded; the shape with the most time in total comes first. Only admins may read them.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} slowquery.Report
+// @Security BearerAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /admin/slow-queries [get]
+func (h slowQueryHandlers) getStats(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	if err := policy.NewGuard(h.users, caller).Authorize(r.Context(), policy.ViewSlowQueries, 0); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.slow.Stats())
+}
+
+// Creates or updates the tables used by the handlers within timeout
+func migrate(timeout config.Duration) {
+	ctx, cancel := deadline.Context(context.Background(), timeout)
+	defer cancel()
+	if err := schema.Migrate(ctx, gormDB); err != nil {
+		logging.Fatal("Failed to migrate the database", err)
+	}
 }
 
 func main() {
-	// Connect to both SQL and GORM databases
-	connectSQL()
-	connectGORM()
+	cfg := config.MustLoad()
+	logger, level := logging.New(cfg.Log, os.Stderr)
+	slog.SetDefault(logger)
+	tracer, err := tracing.Setup(cfg.Tracing, "advancedrestapi")
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", err)
+	}
+	cursorCodec = pagination.NewCodec([]byte(cfg.CursorSecret.Value()))
+
+	// Connect to both SQL and GORM databases, logging their slow statements
+	slow := slowquery.New(cfg.SlowQuery)
+	connectSQL(cfg.Database, slow)
+	connectGORM(cfg.Database, slow)
+	migrate(cfg.Database.Timeouts.Migration)
+
+	mux := http.NewServeMux()
 
 	// Set up Swagger documentation
-	http.Handle("/swagger/", httpSwagger.WrapHandler)
-
-	// Set up routes
-	http.HandleFunc("/sql/users", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			getUsersSQL(w, r)
-		} else if r.Method == http.MethodPost {
-			createUserSQL(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
+	mux.Handle("/swagger/", httpSwagger.WrapHandler)
+
+	gormPool, err := gormDB.DB()
+	if err != nil {
+		logging.Fatal("Failed to get the GORM connection pool", err)
+	}
+
+	// Export request, repository and pool metrics for Prometheus
+	stats := metrics.New()
+	stats.RegisterDB("sql", sqlDB)
+	stats.RegisterDB("gorm", gormPool)
+	mux.Handle("GET /metrics", stats.Handler())
+
+	// Liveness and readiness for the orchestrator; both pools must answer
+	// and the schema must be migrated
+	checker := health.New(cfg.Health)
+	checker.Add("sql", health.Ping(sqlDB))
+	checker.Add("gorm", health.Ping(gormPool))
+	checker.Add("schema", func(ctx context.Context) error { return schema.Check(ctx, sqlDB) })
+	mux.HandleFunc("GET /healthz", checker.Live)
+	mux.HandleFunc("GET /readyz", checker.Ready)
+
+	// Repository operations run under their request's context and the
+	// deadline of their kind
+	timeouts := cfg.Database.Timeouts
+	sqlRepo := stats.Users(deadline.Users(repository.NewSQLRepository(sqlDB), timeouts), "sql")
+	gormRepo := stats.Users(deadline.Users(repository.NewGORMRepository(gormDB), timeouts), "gorm")
+
+	// Shed load before it queues for database connections; the docs, the
+	// health checks and the metrics, which include the limiter's own, are
+	// always served
+	shedder := loadshed.New(cfg.Concurrency, "/swagger/", "/metrics", "/healthz", "/readyz")
+	stats.RegisterLimiter(shedder)
+
+	// Accounts and tokens are stored through database/sql
+	authService := auth.NewService(sqlRepo, stats.Tokens(deadline.Tokens(repository.NewSQLTokenRepository(sqlDB), timeouts), "sql"), cfg.Auth)
+
+	// Break down the database and encoding time in a Server-Timing header,
+	// on every response if configured and otherwise for admins sending
+	// X-Debug-Timing
+	timing := servertiming.New(cfg.ServerTiming, func(r *http.Request) bool {
+		caller, err := authService.Identify(r)
+		return err == nil && policy.NewGuard(sqlRepo, caller).Authorize(r.Context(), policy.ViewServerTiming, 0) == nil
 	})
 
-	http.HandleFunc("/gorm/users", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			getUsersGORM(w, r)
-		} else if r.Method == http.MethodPost {
-			createUserGORM(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	limiter, err := ratelimit.New(cfg.RateLimit, nil)
+	if err != nil {
+		logging.Fatal("Invalid rate limits", err)
+	}
+	authHandlers{svc: authService, users: sqlRepo, limit: limiter.Middleware}.register(mux)
+	logHandlers{level: level, users: sqlRepo, limit: limiter.Middleware}.register(mux, authService.Require(auth.ScopeAdmin))
+	slowQueryHandlers{slow: slow, users: sqlRepo, limit: limiter.Middleware}.register(mux, authService.Require(auth.ScopeAdmin))
+
+	// Set up routes; both backends share the same handlers
+	sqlUsers := userHandlers{repo: sqlRepo, require: authService.Require, limit: limiter.Middleware}
+	sqlUsers.register(mux, "/sql")
+	sqlUsers.registerAdmin(mux)
+	userHandlers{repo: gormRepo, require: authService.Require, limit: limiter.Middleware}.register(mux, "/gorm")
+
+	if name := cfg.Auth.BootstrapAdmin; name != "" {
+		if err := policy.Bootstrap(context.Background(), sqlRepo, name, cfg.Auth.BootstrapAdminPassword.Value()); err != nil {
+			slog.Warn("Could not create the bootstrap admin", "name", name, "err", err)
 		}
-	})
+	}
 
-	fmt.Println("Server started on :8080...")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// Serve until SIGINT/SIGTERM, then drain requests, close both pools and
+	// the EXPLAIN connections and flush the last spans. Every request gets a
+	// span, an ID and an access log line, and is counted, even when shed.
+	slog.Info("Server started", "addr", cfg.Server.Addr)
+	handler := tracing.Middleware(logging.Middleware(logger, timing.Handler(
+		stats.Middleware(problem.Recover(shedder.Middleware(tracing.Route(logging.Route(mux))))))))
+	if err := server.Run(server.New(cfg.Server, handler), cfg.Server, slow, sqlDB, gormPool, tracer); err != nil {
+		logging.Fatal("Server failed", err)
+	}
 }
@@ -1,25 +1,37 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"net/url"
+
+	"assignment2/config"
+	"assignment2/deadline"
+	"assignment2/pagination"
+	"assignment2/querybuilder"
+	"assignment2/txn"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var db *sql.DB
 
+// Signs the cursors returned by QueryUsers
+var cursorCodec *pagination.Codec
+
+// Deadlines of the statements, by kind
+var timeouts config.QueryTimeouts
+
 // Connect to MySQL
-func ConnectMySQL() {
+func ConnectMySQL(cfg config.DatabaseConfig) {
 	var err error
-	connStr := "root:password@tcp(127.0.0.1:3306)/gocon"
-	db, err = sql.Open("mysql", connStr)
+	db, err = sql.Open("mysql", cfg.DSN())
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
-	db.SetMaxOpenConns(25) // Connection pooling
-	db.SetMaxIdleConns(25)
+	cfg.ApplyPool(db) // Connection pooling
 	fmt.Println("Connected to MySQL!")
 }
 
@@ -38,62 +50,94 @@ func CreateTable() {
 	fmt.Println("Table created successfully!")
 }
 
-// Insert users within a transaction
-func InsertUsers() {
-	tx, err := db.Begin()
+// Insert users within a transaction; neither is inserted if either fails,
+// and a deadlock retries both
+func InsertUsers(ctx context.Context) {
+	ctx, cancel := deadline.Context(ctx, timeouts.Write)
+	defer cancel()
+	err := txn.WithTx(ctx, db, nil, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO users (name, age) VALUES (?, ?)", "Alice", 25); err != nil {
+			return fmt.Errorf("inserting Alice: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO users (name, age) VALUES (?, ?)", "Bob", 30); err != nil {
+			return fmt.Errorf("inserting Bob: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatal("Failed to begin transaction:", err)
+		fmt.Println("Failed to insert users:", err)
+		return
 	}
+	fmt.Println("Users inserted successfully!")
+}
 
-	_, err = tx.Exec("INSERT INTO users (name, age) VALUES (?, ?)", "Alice", 25)
+// Query one page of users with filtering and keyset pagination, returning the cursor of the next page
+func QueryUsers(ctx context.Context, params querybuilder.ListParams, cursor string, limit int) string {
+	req, err := cursorCodec.Resume(cursor, limit, params.SortKey())
 	if err != nil {
-		tx.Rollback()
-		log.Fatal("Failed to insert user Alice:", err)
+		log.Fatal("Invalid cursor:", err)
 	}
-
-	_, err = tx.Exec("INSERT INTO users (name, age) VALUES (?, ?)", "Bob", 30)
-	if err != nil {
-		tx.Rollback()
-		log.Fatal("Failed to insert user Bob:", err)
+	if req.Cursor != nil {
+		params.Keyset = &querybuilder.Keyset{Values: req.Cursor.Values, Backward: req.Cursor.Backward}
 	}
 
-	err = tx.Commit()
+	query, args := params.Build("SELECT id, name, age FROM users")
+	query += " LIMIT ?"
+	args = append(args, req.Limit+1)
+
+	ctx, cancel := deadline.Context(ctx, timeouts.List)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Fatal("Failed to commit transaction:", err)
+		log.Fatal("Failed to query users:", err)
 	}
-	fmt.Println("Users inserted successfully!")
-}
-
-// Query users with filtering and pagination
-func QueryUsers(ageFilter string, page int) {
-	limit := 2
-	offset := (page - 1) * limit
+	defer rows.Close()
 
-	query := "SELECT * FROM users"
-	if ageFilter != "" {
-		query += " WHERE age = " + ageFilter
+	type userRow struct {
+		ID   int
+		Name string
+		Age  int
 	}
-	query += " LIMIT ? OFFSET ?"
-
-	rows, err := db.Query(query, limit, offset)
-	if err != nil {
+	var users []userRow
+	for rows.Next() {
+		var u userRow
+		if err := rows.Scan(&u.ID, &u.Name, &u.Age); err != nil {
+			log.Fatal("Failed to read user:", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
 		log.Fatal("Failed to query users:", err)
 	}
-	defer rows.Close()
+
+	fields := params.SortFields()
+	page := pagination.Paginate(cursorCodec, req, users, func(u userRow) []interface{} {
+		values := make([]interface{}, len(fields))
+		for i, f := range fields {
+			switch f.Column {
+			case "name":
+				values[i] = u.Name
+			case "age":
+				values[i] = u.Age
+			default:
+				values[i] = u.ID
+			}
+		}
+		return values
+	})
 
 	fmt.Println("Users:")
-	for rows.Next() {
-		var id int
-		var name string
-		var age int
-		rows.Scan(&id, &name, &age)
-		fmt.Printf("ID: %d, Name: %s, Age: %d\n", id, name, age)
+	for _, u := range page.Items {
+		fmt.Printf("ID: %d, Name: %s, Age: %d\n", u.ID, u.Name, u.Age)
 	}
+	return page.Next
 }
 
 // Update user details by ID
-func UpdateUser(id int, name string, age int) {
-	_, err := db.Exec("UPDATE users SET name = ?, age = ? WHERE id = ?", name, age, id)
+func UpdateUser(ctx context.Context, id int, name string, age int) {
+	ctx, cancel := deadline.Context(ctx, timeouts.Write)
+	defer cancel()
+	_, err := db.ExecContext(ctx, "UPDATE users SET name = ?, age = ? WHERE id = ?", name, age, id)
 	if err != nil {
 		log.Fatal("Failed to update user:", err)
 	}
@@ -101,8 +145,10 @@ func UpdateUser(id int, name string, age int) {
 }
 
 // Delete user by ID
-func DeleteUser(id int) {
-	_, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+func DeleteUser(ctx context.Context, id int) {
+	ctx, cancel := deadline.Context(ctx, timeouts.Write)
+	defer cancel()
+	_, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
 	if err != nil {
 		log.Fatal("Failed to delete user:", err)
 	}
@@ -110,16 +156,29 @@ func DeleteUser(id int) {
 }
 
 func main() {
-	ConnectMySQL()
+	cfg := config.MustLoad()
+	cursorCodec = pagination.NewCodec([]byte(cfg.CursorSecret.Value()))
+	timeouts = cfg.Database.Timeouts
+	ctx := context.Background()
+
+	ConnectMySQL(cfg.Database)
 	CreateTable()
-	InsertUsers()
+	InsertUsers(ctx)
 
-	fmt.Println("Querying users with age filter 25 and page 1")
-	QueryUsers("25", 1)
+	fmt.Println("Querying users with age filter 25, two per page")
+	params, err := querybuilder.Parse(url.Values{"age": {"25"}})
+	if err != nil {
+		log.Fatal("Invalid query parameters:", err)
+	}
+	next := QueryUsers(ctx, params, "", 2)
+	if next != "" {
+		fmt.Println("Querying the next page")
+		QueryUsers(ctx, params, next, 2)
+	}
 
 	fmt.Println("Updating user with ID 1")
-	UpdateUser(1, "Alice Updated", 28)
+	UpdateUser(ctx, 1, "Alice Updated", 28)
 
 	fmt.Println("Deleting user with ID 2")
-	DeleteUser(2)
+	DeleteUser(ctx, 2)
 }
@@ -0,0 +1,212 @@
+// Package apperr defines the domain errors shared by the repositories and
+// both HTTP servers. Every error carries a stable Code that clients can
+// branch on; package problem turns them into RFC 7807 responses.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Code is a stable, machine-readable error code
+type Code string
+
+const (
+	CodeBadRequest        Code = "bad_request"
+	CodeValidation        Code = "validation_failed"
+	CodeUnauthorized      Code = "unauthorized"
+	CodeForbidden         Code = "forbidden"
+	CodeTwoFactorRequired Code = "two_factor_required" // repeat the login with a one-time code
+	CodeNotFound          Code = "not_found"
+	CodeConflict          Code = "conflict"
+	CodeRequestTooLarge   Code = "request_too_large"
+	CodeRateLimited       Code = "rate_limited"
+	CodeUnavailable       Code = "unavailable"
+	CodeCanceled          Code = "canceled" // the client went away before the response
+	CodeTimeout           Code = "timeout"
+	CodeInternal          Code = "internal"
+)
+
+// StatusClientClosedRequest is the nginx convention for a request whose
+// client disconnected; it only shows up in logs and metrics
+const StatusClientClosedRequest = 499
+
+// codeInfo maps each code to its HTTP status and problem title
+var codeInfo = map[Code]struct {
+	status int
+	title  string
+}{
+	CodeBadRequest:        {http.StatusBadRequest, "Bad Request"},
+	CodeValidation:        {http.StatusUnprocessableEntity, "Validation Failed"},
+	CodeUnauthorized:      {http.StatusUnauthorized, "Unauthorized"},
+	CodeForbidden:         {http.StatusForbidden, "Forbidden"},
+	CodeTwoFactorRequired: {http.StatusUnauthorized, "Two-Factor Authentication Required"},
+	CodeNotFound:          {http.StatusNotFound, "Not Found"},
+	CodeConflict:          {http.StatusConflict, "Conflict"},
+	CodeRequestTooLarge:   {http.StatusRequestEntityTooLarge, "Request Too Large"},
+	CodeRateLimited:       {http.StatusTooManyRequests, "Too Many Requests"},
+	CodeUnavailable:       {http.StatusServiceUnavailable, "Service Unavailable"},
+	CodeCanceled:          {StatusClientClosedRequest, "Client Closed Request"},
+	CodeTimeout:           {http.StatusGatewayTimeout, "Timeout"},
+	CodeInternal:          {http.StatusInternalServerError, "Internal Server Error"},
+}
+
+// Status returns the HTTP status for the code
+func (c Code) Status() int {
+	if info, ok := codeInfo[c]; ok {
+		return info.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Title returns the short, human-readable summary for the code
+func (c Code) Title() string {
+	if info, ok := codeInfo[c]; ok {
+		return info.title
+	}
+	return http.StatusText(c.Status())
+}
+
+// FieldError describes a problem with one field of the request, identified by its JSON path
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error
+type Error struct {
+	Code    Code
+	Message string
+	Fields  []FieldError
+	Err     error // underlying cause, if any
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Detail is the message for clients. The cause is only included for
+// malformed requests, where it explains what is wrong with the input. For
+// internal errors it could reveal SQL or schema details, so it is only
+// logged; for other codes it is a driver or library error that the message
+// already describes.
+func (e *Error) Detail() string {
+	if e.Err != nil && e.Code == CodeBadRequest {
+		return e.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a domain error with the same code, so that
+// errors.Is(err, apperr.ErrNotFound) matches every not-found error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Sentinels for use with errors.Is
+var (
+	ErrBadRequest   = &Error{Code: CodeBadRequest, Message: "bad request"}
+	ErrValidation   = &Error{Code: CodeValidation, Message: "validation failed"}
+	ErrUnauthorized = &Error{Code: CodeUnauthorized, Message: "unauthorized"}
+	ErrForbidden    = &Error{Code: CodeForbidden, Message: "forbidden"}
+	ErrNotFound     = &Error{Code: CodeNotFound, Message: "not found"}
+	ErrConflict     = &Error{Code: CodeConflict, Message: "conflict"}
+	ErrUnavailable  = &Error{Code: CodeUnavailable, Message: "unavailable"}
+	ErrCanceled     = &Error{Code: CodeCanceled, Message: "canceled"}
+	ErrTimeout      = &Error{Code: CodeTimeout, Message: "timeout"}
+	ErrInternal     = &Error{Code: CodeInternal, Message: "internal error"}
+)
+
+// NotFound returns a not-found error with a formatted message
+func NotFound(format string, args ...interface{}) error {
+	return &Error{Code: CodeNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// Conflict returns an error for a request that clashes with existing data
+func Conflict(message string, fields ...FieldError) error {
+	return &Error{Code: CodeConflict, Message: message, Fields: fields}
+}
+
+// Validation returns an error listing every invalid field
+func Validation(message string, fields ...FieldError) error {
+	return &Error{Code: CodeValidation, Message: message, Fields: fields}
+}
+
+// Unauthorized returns an error for a request without valid credentials
+func Unauthorized(message string) error {
+	return &Error{Code: CodeUnauthorized, Message: message}
+}
+
+// Forbidden returns an error for an authenticated caller that may not make the request
+func Forbidden(message string) error {
+	return &Error{Code: CodeForbidden, Message: message}
+}
+
+// Unavailable returns an error for a transient failure; the request may be retried
+func Unavailable(message string, err error) error {
+	return &Error{Code: CodeUnavailable, Message: message, Err: err}
+}
+
+// Canceled returns an error for work abandoned because its request was
+// canceled, usually by the client disconnecting
+func Canceled(message string, err error) error {
+	return &Error{Code: CodeCanceled, Message: message, Err: err}
+}
+
+// Timeout returns an error for work that did not finish within its deadline
+func Timeout(message string, err error) error {
+	return &Error{Code: CodeTimeout, Message: message, Err: err}
+}
+
+// BadRequest returns an error for a malformed request, such as invalid JSON
+func BadRequest(message string, err error) error {
+	return &Error{Code: CodeBadRequest, Message: message, Err: err}
+}
+
+// TooLarge returns an error for a request body over limit bytes
+func TooLarge(limit int64) error {
+	return &Error{Code: CodeRequestTooLarge, Message: fmt.Sprintf("request body exceeds %d bytes", limit)}
+}
+
+// RateLimited returns an error for a client that sent too many requests
+func RateLimited(message string) error {
+	return &Error{Code: CodeRateLimited, Message: message}
+}
+
+// InvalidBody classifies an error from decoding the request body: bodies
+// cut off by http.MaxBytesReader are too large, anything else is malformed.
+func InvalidBody(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return TooLarge(tooLarge.Limit)
+	}
+	return BadRequest("Invalid input", err)
+}
+
+// Wrap classifies err: domain errors are returned unchanged, anything else
+// becomes an internal error described by message.
+func Wrap(message string, err error) error {
+	var domain *Error
+	if errors.As(err, &domain) {
+		return err
+	}
+	return &Error{Code: CodeInternal, Message: message, Err: err}
+}
+
+// From returns the domain error in err's chain, treating unknown errors as internal
+func From(err error) *Error {
+	var domain *Error
+	if errors.As(err, &domain) {
+		return domain
+	}
+	return &Error{Code: CodeInternal, Message: "internal error", Err: err}
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"assignment2/apperr"
+	"assignment2/models"
+)
+
+// Scopes grant API keys access to groups of routes
+const (
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeProfilesRead  = "profiles:read"
+	ScopeProfilesWrite = "profiles:write"
+)
+
+// ScopeAdmin grants access to the administration routes. API keys cannot
+// be given it, so a leaked key of an admin cannot assign roles or reset
+// another user's second factor.
+const ScopeAdmin = "admin"
+
+// APIKeyScopes lists the scopes an API key may be given
+var APIKeyScopes = models.Scopes{ScopeUsersRead, ScopeUsersWrite, ScopeProfilesRead, ScopeProfilesWrite}
+
+// AllScopes lists every scope; users logged in with a password have all of them
+var AllScopes = models.Scopes{ScopeUsersRead, ScopeUsersWrite, ScopeProfilesRead, ScopeProfilesWrite, ScopeAdmin}
+
+// APIKeyPrefix starts every API key, telling them apart from access tokens
+const APIKeyPrefix = "ak_"
+
+// Length of the key prefix stored for display, including APIKeyPrefix
+const displayPrefixLen = len(APIKeyPrefix) + 8
+
+// lastUsedResolution limits how often the last-used time of a key is written
+const lastUsedResolution = time.Minute
+
+// APIKeyRequest is the body of a request to create an API key
+type APIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// NewAPIKey is a newly created API key. Key is only ever returned here.
+type NewAPIKey struct {
+	Key string `json:"key"`
+	models.APIKey
+}
+
+// Only users logged in with a password manage API keys, so that a leaked
+// key cannot be used to mint more
+func requireUser(id Identity) error {
+	if id.APIKeyID != 0 {
+		return apperr.Forbidden("API keys cannot be managed with an API key")
+	}
+	return nil
+}
+
+// CreateAPIKey creates an API key for the caller
+func (s *Service) CreateAPIKey(ctx context.Context, id Identity, req APIKeyRequest) (NewAPIKey, error) {
+	if err := requireUser(id); err != nil {
+		return NewAPIKey{}, err
+	}
+
+	var fields []apperr.FieldError
+	if len(req.Scopes) == 0 {
+		fields = append(fields, apperr.FieldError{Field: "scopes", Message: "is required"})
+	}
+	for _, scope := range req.Scopes {
+		if !APIKeyScopes.Has(scope) {
+			fields = append(fields, apperr.FieldError{
+				Field:   "scopes",
+				Message: "must only contain " + strings.Join(APIKeyScopes, ", "),
+			})
+			break
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(s.now()) {
+		fields = append(fields, apperr.FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+	if len(fields) > 0 {
+		return NewAPIKey{}, apperr.Validation("The request body is invalid", fields...)
+	}
+
+	key := APIKeyPrefix + randomHex(32)
+	stored := models.APIKey{
+		UserID:    id.UserID,
+		Name:      req.Name,
+		Prefix:    key[:displayPrefixLen],
+		Hash:      hashToken(key),
+		Scopes:    models.Scopes(req.Scopes),
+		CreatedAt: s.now(),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.tokens.CreateAPIKey(ctx, &stored); err != nil {
+		return NewAPIKey{}, err
+	}
+	return NewAPIKey{Key: key, APIKey: stored}, nil
+}
+
+// ListAPIKeys returns the caller's API keys, including revoked and expired ones
+func (s *Service) ListAPIKeys(ctx context.Context, id Identity) ([]models.APIKey, error) {
+	if err := requireUser(id); err != nil {
+		return nil, err
+	}
+	keys, err := s.tokens.ListAPIKeys(ctx, id.UserID)
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+	return keys, err
+}
+
+// RevokeAPIKey revokes one of the caller's API keys
+func (s *Service) RevokeAPIKey(ctx context.Context, id Identity, keyID uint) error {
+	if err := requireUser(id); err != nil {
+		return err
+	}
+	return s.tokens.RevokeAPIKey(ctx, id.UserID, keyID, s.now())
+}
+
+// Checks an API key and returns the identity it carries
+func (s *Service) authenticateAPIKey(ctx context.Context, key string) (Identity, error) {
+	stored, err := s.tokens.GetAPIKey(ctx, hashToken(key))
+	if errors.Is(err, apperr.ErrNotFound) {
+		return Identity{}, errBadAPIKey
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	now := s.now()
+	if stored.RevokedAt != nil || (stored.ExpiresAt != nil && !stored.ExpiresAt.After(now)) {
+		return Identity{}, errBadAPIKey
+	}
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= lastUsedResolution {
+		if err := s.tokens.TouchAPIKey(ctx, stored.ID, now); err != nil {
+			return Identity{}, err
+		}
+	}
+	return Identity{UserID: stored.UserID, APIKeyID: stored.ID, Scopes: stored.Scopes}, nil
+}
@@ -0,0 +1,302 @@
+// Package auth implements password login with short-lived JWT access tokens
+// and rotating refresh tokens, scoped API keys for services, and the Gin and
+// net/http middleware that authenticates requests with them.
+//
+// Users can enable TOTP (RFC 6238) two-factor authentication, after which a
+// login also needs a one-time code or one of their single-use recovery codes.
+//
+// Refresh tokens are single use: each refresh revokes the presented token
+// and issues a new one. Presenting a revoked refresh token again means it
+// was stolen, so every refresh token of its user is revoked. Logging out
+// revokes the refresh token and records the access token as revoked until
+// it expires.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"assignment2/apperr"
+	"assignment2/config"
+	"assignment2/models"
+	"assignment2/repository"
+)
+
+// Registration is the body of a registration request
+type Registration struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	Age      int    `json:"age" validate:"min=0,max=150"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// Credentials is the body of a login request. Users with two-factor
+// authentication also send the current OTP from their authenticator or one
+// of their recovery codes.
+type Credentials struct {
+	Name         string `json:"name" validate:"required"`
+	Password     string `json:"password" validate:"required"`
+	OTP          string `json:"otp"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RefreshRequest is the body of refresh and logout requests
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Session is returned on registration, login and refresh
+type Session struct {
+	User         models.User `json:"user"`
+	AccessToken  string      `json:"access_token"`
+	TokenType    string      `json:"token_type"`
+	ExpiresIn    int64       `json:"expires_in"` // lifetime of the access token in seconds
+	RefreshToken string      `json:"refresh_token"`
+}
+
+// Identity is the authenticated caller of a request: a user with an access
+// token, or a service with an API key created by that user
+type Identity struct {
+	UserID    uint
+	Name      string
+	TokenID   string    // jti of the access token
+	ExpiresAt time.Time // expiry of the access token
+	APIKeyID  uint      // set when authenticated with an API key
+	Scopes    models.Scopes
+}
+
+// HasScope reports whether the caller was granted scope
+func (id Identity) HasScope(scope string) bool {
+	return id.Scopes.Has(scope)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity stored in ctx by the middleware
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
+
+// Service registers and logs in users and issues and checks their tokens
+type Service struct {
+	users      repository.UserRepository
+	tokens     repository.TokenRepository
+	key        []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	issuer     string // TOTP issuer
+	now        func() time.Time
+}
+
+// NewService returns a service for the users and tokens in the given
+// repositories. Without cfg.Secret a random key is used, so access tokens
+// become invalid when the process restarts.
+func NewService(users repository.UserRepository, tokens repository.TokenRepository, cfg config.AuthConfig) *Service {
+	key := []byte(cfg.Secret.Value())
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &Service{
+		users:      users,
+		tokens:     tokens,
+		key:        key,
+		accessTTL:  cfg.AccessTokenTTL.Duration,
+		refreshTTL: cfg.RefreshTokenTTL.Duration,
+		issuer:     cfg.TOTPIssuer,
+		now:        time.Now,
+	}
+}
+
+var (
+	errBadCredentials = apperr.Unauthorized("invalid name or password")
+	errBadRefresh     = apperr.Unauthorized("invalid or expired refresh token")
+	errBadAccess      = apperr.Unauthorized("invalid or expired access token")
+	errRevokedAccess  = apperr.Unauthorized("access token has been revoked")
+	errBadAPIKey      = apperr.Unauthorized("invalid, expired or revoked API key")
+)
+
+// Register creates a user with the given password and logs it in
+func (s *Service) Register(ctx context.Context, reg Registration) (Session, error) {
+	hash, err := HashPassword(reg.Password)
+	if err != nil {
+		return Session{}, err
+	}
+	user := models.User{Name: reg.Name, Age: reg.Age, PasswordHash: hash}
+	if err := s.users.Create(ctx, &user); err != nil {
+		return Session{}, err
+	}
+	return s.issue(ctx, user)
+}
+
+// Login checks the credentials and starts a new session
+func (s *Service) Login(ctx context.Context, creds Credentials) (Session, error) {
+	user, err := s.users.GetByName(ctx, creds.Name)
+	if err != nil && !errors.Is(err, apperr.ErrNotFound) {
+		return Session{}, err
+	}
+	// Unknown users are checked against an empty hash, which takes as long
+	if !CheckPassword(user.PasswordHash, creds.Password) {
+		return Session{}, errBadCredentials
+	}
+	if err := s.checkSecondFactor(ctx, user, creds); err != nil {
+		return Session{}, err
+	}
+	return s.issue(ctx, user)
+}
+
+// Refresh exchanges a refresh token for a new session, revoking the token
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (Session, error) {
+	token, err := s.tokens.GetRefreshToken(ctx, hashToken(refreshToken))
+	if errors.Is(err, apperr.ErrNotFound) {
+		return Session{}, errBadRefresh
+	}
+	if err != nil {
+		return Session{}, err
+	}
+
+	now := s.now()
+	if token.RevokedAt != nil {
+		return Session{}, s.reused(ctx, token)
+	}
+	if !token.ExpiresAt.After(now) {
+		return Session{}, errBadRefresh
+	}
+	// Of concurrent refreshes with the same token only one revokes it
+	revoked, err := s.tokens.RevokeRefreshToken(ctx, token.ID, now)
+	if err != nil {
+		return Session{}, err
+	}
+	if !revoked {
+		return Session{}, s.reused(ctx, token)
+	}
+
+	user, err := s.users.Get(ctx, token.UserID)
+	if errors.Is(err, apperr.ErrNotFound) {
+		return Session{}, errBadRefresh
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	return s.issue(ctx, user)
+}
+
+// Handles a revoked refresh token being presented again: whoever holds the
+// token family now, the legitimate user has to log in again
+func (s *Service) reused(ctx context.Context, token models.RefreshToken) error {
+	if err := s.tokens.RevokeUserRefreshTokens(ctx, token.UserID, s.now()); err != nil {
+		return err
+	}
+	return errBadRefresh
+}
+
+// Logout revokes the caller's access token and, if it belongs to the caller,
+// the refresh token. Unknown or already revoked refresh tokens are ignored.
+func (s *Service) Logout(ctx context.Context, id Identity, refreshToken string) error {
+	if id.APIKeyID != 0 {
+		return apperr.Forbidden("API keys cannot log out; revoke the key instead")
+	}
+	if err := s.tokens.RevokeAccessToken(ctx, models.RevokedToken{ID: id.TokenID, ExpiresAt: id.ExpiresAt}); err != nil {
+		return err
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	token, err := s.tokens.GetRefreshToken(ctx, hashToken(refreshToken))
+	if errors.Is(err, apperr.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if token.UserID == id.UserID {
+		_, err = s.tokens.RevokeRefreshToken(ctx, token.ID, s.now())
+	}
+	return err
+}
+
+// Authenticate checks an access token or API key and returns the identity it carries
+func (s *Service) Authenticate(ctx context.Context, token string) (Identity, error) {
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		return s.authenticateAPIKey(ctx, token)
+	}
+	return s.authenticateAccessToken(ctx, token)
+}
+
+func (s *Service) authenticateAccessToken(ctx context.Context, accessToken string) (Identity, error) {
+	claims, err := parseJWT(s.key, accessToken, s.now())
+	if err != nil {
+		return Identity{}, errBadAccess
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return Identity{}, errBadAccess
+	}
+
+	revoked, err := s.tokens.IsAccessTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return Identity{}, err
+	}
+	if revoked {
+		return Identity{}, errRevokedAccess
+	}
+	// Access tokens are not stored, so one issued before the user was
+	// deleted stays valid until it expires unless the user is looked up
+	_, err = s.users.Get(ctx, uint(userID))
+	if errors.Is(err, apperr.ErrNotFound) {
+		return Identity{}, errBadAccess
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{
+		UserID:    uint(userID),
+		Name:      claims.Name,
+		TokenID:   claims.ID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		Scopes:    AllScopes,
+	}, nil
+}
+
+// Issues a new access and refresh token for user
+func (s *Service) issue(ctx context.Context, user models.User) (Session, error) {
+	now := s.now()
+	access, err := signJWT(s.key, Claims{
+		ID:        randomHex(16),
+		Subject:   strconv.FormatUint(uint64(user.ID), 10),
+		Name:      user.Name,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.accessTTL).Unix(),
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	refresh := randomHex(32)
+	err = s.tokens.CreateRefreshToken(ctx, &models.RefreshToken{
+		UserID:    user.ID,
+		Hash:      hashToken(refresh),
+		ExpiresAt: now.Add(s.refreshTTL),
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return Session{
+		User:         user,
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"assignment2/config"
+	"assignment2/repository"
+)
+
+// Returns a service on in-memory repositories with 15-minute access tokens
+// and one-day refresh tokens, on a clock that only moves when the test says so
+func newTestService(t *testing.T) (*Service, *time.Time) {
+	t.Helper()
+	tokens := repository.NewMemoryTokenRepository()
+	s := NewService(repository.NewMemoryRepository(tokens), tokens, config.AuthConfig{
+		AccessTokenTTL:  config.Duration{Duration: 15 * time.Minute},
+		RefreshTokenTTL: config.Duration{Duration: 24 * time.Hour},
+		TOTPIssuer:      "test",
+	})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func register(t *testing.T, s *Service, name string) Session {
+	t.Helper()
+	session, err := s.Register(context.Background(), Registration{Name: name, Age: 30, Password: name + "-password"})
+	if err != nil {
+		t.Fatalf("register %s: %v", name, err)
+	}
+	return session
+}
+
+func TestAccessTokenExpires(t *testing.T) {
+	s, now := newTestService(t)
+	ctx := context.Background()
+	session := register(t, s, "bob")
+
+	id, err := s.Authenticate(ctx, session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.UserID != session.User.ID || id.Name != "bob" || !id.ExpiresAt.Equal(now.Add(15*time.Minute)) {
+		t.Errorf("identity = %+v", id)
+	}
+
+	*now = now.Add(15 * time.Minute)
+	if _, err := s.Authenticate(ctx, session.AccessToken); !errors.Is(err, errBadAccess) {
+		t.Errorf("expired token: err = %v, want %v", err, errBadAccess)
+	}
+}
+
+func TestRefreshRotates(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	first := register(t, s, "bob")
+
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Error("refresh returned the same tokens")
+	}
+	if _, err := s.Authenticate(ctx, second.AccessToken); err != nil {
+		t.Errorf("new access token: %v", err)
+	}
+	if _, err := s.Refresh(ctx, second.RefreshToken); err != nil {
+		t.Errorf("refresh with the new token: %v", err)
+	}
+}
+
+// Presenting a refresh token that was already exchanged revokes every
+// refresh token of its user, including the one the thief got for it
+func TestRefreshReuseRevokesTheUsersTokens(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	stolen := register(t, s, "bob")
+	otherDevice, err := s.Login(ctx, Credentials{Name: "bob", Password: "bob-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := register(t, s, "alice")
+
+	rotated, err := s.Refresh(ctx, stolen.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refresh(ctx, stolen.RefreshToken); !errors.Is(err, errBadRefresh) {
+		t.Fatalf("reused token: err = %v, want %v", err, errBadRefresh)
+	}
+
+	for name, token := range map[string]string{"rotated": rotated.RefreshToken, "other device": otherDevice.RefreshToken} {
+		if _, err := s.Refresh(ctx, token); !errors.Is(err, errBadRefresh) {
+			t.Errorf("%s token after reuse: err = %v, want %v", name, err, errBadRefresh)
+		}
+	}
+	if _, err := s.Refresh(ctx, alice.RefreshToken); err != nil {
+		t.Errorf("another user's token after reuse: %v", err)
+	}
+}
+
+func TestRefreshRejects(t *testing.T) {
+	s, now := newTestService(t)
+	ctx := context.Background()
+	session := register(t, s, "bob")
+
+	if _, err := s.Refresh(ctx, "unknown"); !errors.Is(err, errBadRefresh) {
+		t.Errorf("unknown token: err = %v, want %v", err, errBadRefresh)
+	}
+	*now = now.Add(24 * time.Hour)
+	if _, err := s.Refresh(ctx, session.RefreshToken); !errors.Is(err, errBadRefresh) {
+		t.Errorf("expired token: err = %v, want %v", err, errBadRefresh)
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	session := register(t, s, "bob")
+	id, err := s.Authenticate(ctx, session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Logout(ctx, id, session.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(ctx, session.AccessToken); !errors.Is(err, errRevokedAccess) {
+		t.Errorf("access token after logout: err = %v, want %v", err, errRevokedAccess)
+	}
+	if _, err := s.Refresh(ctx, session.RefreshToken); !errors.Is(err, errBadRefresh) {
+		t.Errorf("refresh token after logout: err = %v, want %v", err, errBadRefresh)
+	}
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"assignment2/apperr"
+	"assignment2/problem"
+)
+
+// Middleware rejects requests without a valid access token or API key with
+// a 401 problem response, and otherwise passes them on with the caller's
+// Identity in the request context. The credential is read from
+// "Authorization: Bearer <token>" or, for API keys, also from X-API-Key.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return s.Require("")(next)
+}
+
+// Require returns middleware like Middleware that also rejects callers
+// without scope with a 403. An empty scope only requires authentication.
+func (s *Service) Require(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, err := s.authorize(r, scope)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GinMiddleware is Middleware for Gin
+func (s *Service) GinMiddleware() gin.HandlerFunc {
+	return s.GinRequire("")
+}
+
+// GinRequire is Require for Gin
+func (s *Service) GinRequire(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, err := s.authorize(c.Request, scope)
+		if err != nil {
+			writeError(c.Writer, c.Request, err)
+			c.Abort()
+			return
+		}
+		c.Request = r
+		c.Next()
+	}
+}
+
+// Identify authenticates the credential of r, like Middleware, for
+// middleware that runs before the routes authenticate
+func (s *Service) Identify(r *http.Request) (Identity, error) {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		scheme, credentials, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return Identity{}, apperr.Unauthorized("a bearer access token or API key is required")
+		}
+		token = strings.TrimSpace(credentials)
+	} else if !strings.HasPrefix(token, APIKeyPrefix) {
+		return Identity{}, errBadAPIKey
+	}
+	return s.Authenticate(r.Context(), token)
+}
+
+// Returns r with the caller's identity in the context, if it has scope
+func (s *Service) authorize(r *http.Request, scope string) (*http.Request, error) {
+	id, err := s.Identify(r)
+	if err != nil {
+		return r, err
+	}
+	if scope != "" && !id.HasScope(scope) {
+		return r, apperr.Forbidden("the " + scope + " scope is required")
+	}
+	return r.WithContext(NewContext(r.Context(), id)), nil
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, apperr.ErrUnauthorized) {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+	}
+	problem.Write(w, r, apperr.Wrap("Failed to authenticate", err))
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"assignment2/apperr"
+)
+
+// HashPassword returns the bcrypt hash of password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return "", apperr.Validation("The request body is invalid",
+			apperr.FieldError{Field: "password", Message: "must be at most 72 bytes"})
+	}
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches hash. An empty hash, as
+// stored for users created without a password, matches nothing.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		// Spend the same time as a real comparison so that response times
+		// do not reveal which users exist
+		bcrypt.CompareHashAndPassword([]byte(dummyHash()), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return string(hash)
+})
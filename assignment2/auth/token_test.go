@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestJWTRoundTrip(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	claims := Claims{ID: "abc", Subject: "7", Name: "bob", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	token, err := signJWT(testKey, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseJWT(testKey, token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != claims {
+		t.Errorf("claims = %+v, want %+v", got, claims)
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	token, _ := signJWT(testKey, Claims{Subject: "7", ExpiresAt: now.Add(time.Minute).Unix()})
+
+	if _, err := parseJWT(testKey, token, now.Add(time.Minute-time.Second)); err != nil {
+		t.Errorf("a second before expiry: %v", err)
+	}
+	for _, at := range []time.Time{now.Add(time.Minute), now.Add(time.Hour)} {
+		if _, err := parseJWT(testKey, token, at); err != ErrInvalidToken {
+			t.Errorf("at %v: err = %v, want ErrInvalidToken", at.Sub(now), err)
+		}
+	}
+}
+
+// Returns a token with the given header, signed with HMAC-SHA256 under key
+func tokenWithHeader(key []byte, header string, claims string) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac(key, unsigned))
+}
+
+func TestJWTRejectsForgedTokens(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	const claims = `{"jti":"abc","sub":"7","exp":1700000060}`
+	valid := tokenWithHeader(testKey, `{"alg":"HS256","typ":"JWT"}`, claims)
+	if _, err := parseJWT(testKey, valid, now); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	parts := strings.Split(valid, ".")
+	admin := base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"abc","sub":"1","exp":1700000060}`))
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+
+	tests := map[string]string{
+		"alg none unsigned":    none + "." + parts[1] + ".",
+		"alg none signed":      tokenWithHeader(testKey, `{"alg":"none"}`, claims),
+		"alg HS512":            tokenWithHeader(testKey, `{"alg":"HS512","typ":"JWT"}`, claims),
+		"alg RS256":            tokenWithHeader(testKey, `{"alg":"RS256","typ":"JWT"}`, claims),
+		"alg missing":          tokenWithHeader(testKey, `{"typ":"JWT"}`, claims),
+		"other key":            tokenWithHeader([]byte("another key"), `{"alg":"HS256","typ":"JWT"}`, claims),
+		"changed claims":       parts[0] + "." + admin + "." + parts[2],
+		"no signature":         parts[0] + "." + parts[1] + ".",
+		"two parts":            parts[0] + "." + parts[1],
+		"four parts":           valid + ".x",
+		"header not base64":    "!." + parts[1] + "." + parts[2],
+		"signature not base64": parts[0] + "." + parts[1] + ".!",
+		"claims not JSON":      tokenWithHeader(testKey, `{"alg":"HS256"}`, `not json`),
+		"empty":                "",
+	}
+	for name, token := range tests {
+		if _, err := parseJWT(testKey, token, now); err != ErrInvalidToken {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+
+	"assignment2/apperr"
+	"assignment2/models"
+)
+
+// Number of recovery codes issued when two-factor authentication is enabled
+const recoveryCodeCount = 10
+
+// Enrollment is returned when a user starts enrolling in two-factor
+// authentication. The secret is entered into an authenticator app, or
+// imported from the provisioning URI or its QR code.
+type Enrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// OneTimeCode is the body of a request to confirm an enrollment
+type OneTimeCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// RecoveryCodes are returned once, when two-factor authentication is enabled
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+var (
+	errTwoFactorRequired = &apperr.Error{
+		Code:    apperr.CodeTwoFactorRequired,
+		Message: "a one-time code or recovery code is required",
+	}
+	errBadOneTimeCode = apperr.Unauthorized("invalid one-time code or recovery code")
+)
+
+// EnrollTwoFactor starts enrolling the caller, replacing an unconfirmed
+// enrollment. Logins only require a code once ConfirmTwoFactor succeeds.
+func (s *Service) EnrollTwoFactor(ctx context.Context, id Identity) (Enrollment, error) {
+	if err := requireUser(id); err != nil {
+		return Enrollment{}, err
+	}
+	existing, err := s.tokens.GetTwoFactor(ctx, id.UserID)
+	if err != nil && !errors.Is(err, apperr.ErrNotFound) {
+		return Enrollment{}, err
+	}
+	if err == nil && existing.Enabled() {
+		return Enrollment{}, apperr.Conflict("two-factor authentication is already enabled")
+	}
+
+	tf := models.TwoFactor{UserID: id.UserID, Secret: newTOTPSecret()}
+	if err := s.tokens.SaveTwoFactor(ctx, &tf); err != nil {
+		return Enrollment{}, err
+	}
+	return Enrollment{Secret: tf.Secret, ProvisioningURI: provisioningURI(s.issuer, id.Name, tf.Secret)}, nil
+}
+
+// Returns the caller's unconfirmed enrollment
+func (s *Service) pendingTwoFactor(ctx context.Context, id Identity) (models.TwoFactor, error) {
+	if err := requireUser(id); err != nil {
+		return models.TwoFactor{}, err
+	}
+	tf, err := s.tokens.GetTwoFactor(ctx, id.UserID)
+	if err == nil && tf.Enabled() {
+		return tf, apperr.Conflict("two-factor authentication is already enabled")
+	}
+	return tf, err
+}
+
+// TwoFactorQRCode returns the provisioning URI of the caller's unconfirmed
+// enrollment as a PNG QR code
+func (s *Service) TwoFactorQRCode(ctx context.Context, id Identity) ([]byte, error) {
+	tf, err := s.pendingTwoFactor(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return qrcode.Encode(provisioningURI(s.issuer, id.Name, tf.Secret), qrcode.Medium, 256)
+}
+
+// ConfirmTwoFactor enables two-factor authentication for the caller once
+// code proves the authenticator is set up, and returns the recovery codes
+func (s *Service) ConfirmTwoFactor(ctx context.Context, id Identity, code string) (RecoveryCodes, error) {
+	tf, err := s.pendingTwoFactor(ctx, id)
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+	step, ok := matchTOTP(tf.Secret, code, s.now())
+	if !ok {
+		return RecoveryCodes{}, apperr.Validation("The request body is invalid",
+			apperr.FieldError{Field: "code", Message: "is not the current code of the authenticator"})
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	stored := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw := randomHex(5)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		stored[i] = models.RecoveryCode{Hash: hashRecoveryCode(codes[i])}
+	}
+	if err := s.tokens.ConfirmTwoFactor(ctx, tf.ID, step, s.now(), stored); err != nil {
+		return RecoveryCodes{}, err
+	}
+	return RecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// ResetTwoFactor removes the two-factor enrollment of a user, e.g. one who
+// lost both the authenticator and the recovery codes. Callers must check
+// that the caller may do so.
+func (s *Service) ResetTwoFactor(ctx context.Context, userID uint) error {
+	return s.tokens.DeleteTwoFactor(ctx, userID)
+}
+
+// Checks the second factor of a login, if the user has enabled it
+func (s *Service) checkSecondFactor(ctx context.Context, user models.User, creds Credentials) error {
+	tf, err := s.tokens.GetTwoFactor(ctx, user.ID)
+	if errors.Is(err, apperr.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled() {
+		return nil
+	}
+
+	switch {
+	case creds.OTP != "":
+		step, ok := matchTOTP(tf.Secret, creds.OTP, s.now())
+		if !ok {
+			return errBadOneTimeCode
+		}
+		// Each code is accepted once, even within its 30 seconds
+		fresh, err := s.tokens.UseTOTPStep(ctx, tf.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errBadOneTimeCode
+		}
+	case creds.RecoveryCode != "":
+		used, err := s.tokens.UseRecoveryCode(ctx, tf.ID, hashRecoveryCode(creds.RecoveryCode), s.now())
+		if err != nil {
+			return err
+		}
+		if !used {
+			return errBadOneTimeCode
+		}
+	default:
+		return errTwoFactorRequired
+	}
+	return nil
+}
+
+// Recovery codes are compared case-insensitively and without the dash
+func hashRecoveryCode(code string) string {
+	return hashToken(strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", "")))
+}
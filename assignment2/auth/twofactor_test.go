@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The secret of the RFC 4226 and RFC 6238 test vectors
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 4226, appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := hotp([]byte("12345678901234567890"), int64(counter)); got != code {
+			t.Errorf("hotp(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// Returns the code of secret for the given time step
+func codeAt(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hotp(key, step)
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	now := time.Unix(100*totpPeriod+5, 0) // step 100
+	tests := []struct {
+		step   int64
+		wantOK bool
+	}{
+		{98, false},
+		{99, true},
+		{100, true},
+		{101, true},
+		{102, false},
+	}
+	for _, tt := range tests {
+		step, ok := matchTOTP(rfcSecret, codeAt(t, rfcSecret, tt.step), now)
+		if ok != tt.wantOK || (ok && step != tt.step) {
+			t.Errorf("code of step %d: (%d, %v), want (%d, %v)", tt.step, step, ok, tt.step, tt.wantOK)
+		}
+	}
+
+	// RFC 6238, appendix B: 94287082 at T = 59, of which apps show the last six digits
+	if step, ok := matchTOTP(rfcSecret, "287082", time.Unix(59, 0)); !ok || step != 1 {
+		t.Errorf("RFC 6238 vector: (%d, %v), want (1, true)", step, ok)
+	}
+
+	code := codeAt(t, rfcSecret, 100)
+	for _, input := range []string{code[:3] + " " + code[3:], code} {
+		if _, ok := matchTOTP(strings.ToLower(rfcSecret), input, now); !ok {
+			t.Errorf("%q with a lower case secret was rejected", input)
+		}
+	}
+	for _, input := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := matchTOTP(rfcSecret, input, now); ok {
+			t.Errorf("%q was accepted", input)
+		}
+	}
+}
+
+// Registers bob and enables two-factor authentication, returning the TOTP
+// secret and recovery codes
+func enableTwoFactor(t *testing.T, s *Service) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	session := register(t, s, "bob")
+	id, err := s.Authenticate(ctx, session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := s.EnrollTwoFactor(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := s.now().Unix() / totpPeriod
+	codes, err := s.ConfirmTwoFactor(ctx, id, codeAt(t, enrollment.Secret, step))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("%d recovery codes, want %d", len(codes.RecoveryCodes), recoveryCodeCount)
+	}
+	return enrollment.Secret, codes.RecoveryCodes
+}
+
+func TestLoginRejectsReplayedTOTP(t *testing.T) {
+	s, now := newTestService(t)
+	ctx := context.Background()
+	secret, _ := enableTwoFactor(t, s)
+	login := func(otp string) error {
+		_, err := s.Login(ctx, Credentials{Name: "bob", Password: "bob-password", OTP: otp})
+		return err
+	}
+	step := now.Unix() / totpPeriod
+
+	if err := login(""); !errors.Is(err, errTwoFactorRequired) {
+		t.Errorf("without a code: err = %v, want %v", err, errTwoFactorRequired)
+	}
+	// The code that confirmed the enrollment is spent
+	if err := login(codeAt(t, secret, step)); !errors.Is(err, errBadOneTimeCode) {
+		t.Errorf("confirmation code: err = %v, want %v", err, errBadOneTimeCode)
+	}
+
+	*now = now.Add(totpPeriod * time.Second)
+	if err := login(codeAt(t, secret, step+1)); err != nil {
+		t.Fatalf("next code: %v", err)
+	}
+	if err := login(codeAt(t, secret, step+1)); !errors.Is(err, errBadOneTimeCode) {
+		t.Errorf("same code again: err = %v, want %v", err, errBadOneTimeCode)
+	}
+	// Earlier steps within the skew are spent too, later ones are not
+	if err := login(codeAt(t, secret, step)); !errors.Is(err, errBadOneTimeCode) {
+		t.Errorf("previous code: err = %v, want %v", err, errBadOneTimeCode)
+	}
+	if err := login(codeAt(t, secret, step+2)); err != nil {
+		t.Errorf("code of the following step: %v", err)
+	}
+	if err := login(codeAt(t, secret, step+5)); !errors.Is(err, errBadOneTimeCode) {
+		t.Errorf("code outside the skew: err = %v, want %v", err, errBadOneTimeCode)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	_, codes := enableTwoFactor(t, s)
+	login := func(code string) error {
+		_, err := s.Login(ctx, Credentials{Name: "bob", Password: "bob-password", RecoveryCode: code})
+		return err
+	}
+
+	if err := login(codes[0]); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := login(codes[0]); !errors.Is(err, errBadOneTimeCode) {
+		t.Errorf("second use: err = %v, want %v", err, errBadOneTimeCode)
+	}
+
+	// Codes may be typed in upper case and without the dash, and are still
+	// used up
+	if err := login(strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))); err != nil {
+		t.Fatalf("retyped code: %v", err)
+	}
+	if err := login(codes[1]); !errors.Is(err, errBadOneTimeCode) {
+		t.Errorf("code used in another form: err = %v, want %v", err, errBadOneTimeCode)
+	}
+
+	if err := login("00000-00000"); !errors.Is(err, errBadOneTimeCode) {
+		t.Errorf("made-up code: err = %v, want %v", err, errBadOneTimeCode)
+	}
+	// A wrong password fails before the code is spent
+	if _, err := s.Login(ctx, Credentials{Name: "bob", Password: "wrong", RecoveryCode: codes[2]}); !errors.Is(err, errBadCredentials) {
+		t.Errorf("wrong password: err = %v, want %v", err, errBadCredentials)
+	}
+	if err := login(codes[2]); err != nil {
+		t.Errorf("code after a failed login: %v", err)
+	}
+}
@@ -0,0 +1,453 @@
+// Package config loads the settings shared by the programs in this module.
+//
+// Settings are layered, each layer overriding the previous one:
+//
+//  1. built-in defaults
+//  2. a YAML or TOML file given by -config or CONFIG_FILE
+//  3. environment variables (DB_HOST, LISTEN_ADDR, ...)
+//  4. command-line flags (-db-host, -listen-addr, ...)
+//
+// Secrets can also be read from files (DB_PASSWORD_FILE, -db-password-file,
+// password_file), as with Docker and Kubernetes secrets.
+package config
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Config is the effective configuration of a program
+type Config struct {
+	Database     DatabaseConfig     `yaml:"database" toml:"database"`
+	Server       ServerConfig       `yaml:"server" toml:"server"`
+	Log          LogConfig          `yaml:"log" toml:"log"`
+	Auth         AuthConfig         `yaml:"auth" toml:"auth"`
+	RateLimit    RateLimitConfig    `yaml:"rate_limit" toml:"rate_limit"`
+	Concurrency  ConcurrencyConfig  `yaml:"concurrency" toml:"concurrency"`
+	Health       HealthConfig       `yaml:"health" toml:"health"`
+	Tracing      TracingConfig      `yaml:"tracing" toml:"tracing"`
+	ServerTiming ServerTimingConfig `yaml:"server_timing" toml:"server_timing"`
+	SlowQuery    SlowQueryConfig    `yaml:"slow_query" toml:"slow_query"`
+	CursorSecret Secret             `yaml:"cursor_secret" toml:"cursor_secret"`
+	// CursorSecretFile, if set, replaces CursorSecret with the file's contents
+	CursorSecretFile string `yaml:"cursor_secret_file,omitempty" toml:"cursor_secret_file,omitempty"`
+}
+
+// DatabaseConfig describes the MySQL connection and its pool
+type DatabaseConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	User     string `yaml:"user" toml:"user"`
+	Password Secret `yaml:"password" toml:"password"`
+	// PasswordFile, if set, replaces Password with the file's contents
+	PasswordFile string `yaml:"password_file,omitempty" toml:"password_file,omitempty"`
+	Name         string `yaml:"name" toml:"name"`
+
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+
+	ConnectTimeout Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	ReadTimeout    Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout   Duration `yaml:"write_timeout" toml:"write_timeout"`
+
+	// Timeouts bound the repository operations, so that a stuck query does
+	// not hold its connection forever
+	Timeouts QueryTimeouts `yaml:"timeouts" toml:"timeouts"`
+}
+
+// QueryTimeouts are the deadlines of database operations by kind. A
+// request's own cancellation still applies; 0 leaves only that.
+type QueryTimeouts struct {
+	// List bounds listing and counting users or API keys
+	List Duration `yaml:"list" toml:"list"`
+	// Get bounds reading a single record
+	Get Duration `yaml:"get" toml:"get"`
+	// Write bounds creating, changing or deleting records
+	Write Duration `yaml:"write" toml:"write"`
+	// Migration bounds the schema migration at startup
+	Migration Duration `yaml:"migration" toml:"migration"`
+}
+
+// ServerConfig describes the HTTP server and its limits
+type ServerConfig struct {
+	Addr              string   `yaml:"addr" toml:"addr"`
+	ReadTimeout       Duration `yaml:"read_timeout" toml:"read_timeout"`
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests may take to finish on SIGINT/SIGTERM
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	MaxHeaderBytes  int      `yaml:"max_header_bytes" toml:"max_header_bytes"`
+	MaxBodyBytes    int64    `yaml:"max_body_bytes" toml:"max_body_bytes"`
+}
+
+// LogConfig describes logging
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level"`
+	// Format is "text" or "json"
+	Format string `yaml:"format" toml:"format"`
+}
+
+// AuthConfig describes the tokens issued by package auth
+type AuthConfig struct {
+	// Secret signs access tokens; if empty, a random key is used and tokens
+	// do not survive a restart
+	Secret Secret `yaml:"secret" toml:"secret"`
+	// SecretFile, if set, replaces Secret with the file's contents
+	SecretFile      string   `yaml:"secret_file,omitempty" toml:"secret_file,omitempty"`
+	AccessTokenTTL  Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`
+	RefreshTokenTTL Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"`
+	// TOTPIssuer names this service in authenticator apps
+	TOTPIssuer string `yaml:"totp_issuer" toml:"totp_issuer"`
+	// BootstrapAdmin names an admin account that is created at startup with
+	// BootstrapAdminPassword unless it exists
+	BootstrapAdmin         string `yaml:"bootstrap_admin,omitempty" toml:"bootstrap_admin,omitempty"`
+	BootstrapAdminPassword Secret `yaml:"bootstrap_admin_password,omitempty" toml:"bootstrap_admin_password,omitempty"`
+	// BootstrapAdminPasswordFile, if set, replaces BootstrapAdminPassword
+	// with the file's contents
+	BootstrapAdminPasswordFile string `yaml:"bootstrap_admin_password_file,omitempty" toml:"bootstrap_admin_password_file,omitempty"`
+}
+
+// RateLimitConfig describes the request limits of each client. Clients are
+// identified by their API key or user, or by IP address before they log in.
+type RateLimitConfig struct {
+	// Default applies to routes without a rule of their own, which share one
+	// budget per client
+	Default RateLimitRule `yaml:"default" toml:"default"`
+	// Routes maps routes such as "POST /auth/login", written as registered
+	// with the router, to their own rule
+	Routes map[string]RateLimitRule `yaml:"routes" toml:"routes"`
+	// TrustedProxies lists the addresses or CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For header is believed
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// RateLimitRule allows Requests per Per on average, in bursts of up to Burst
+// requests (by default Requests). A rule without requests is no limit.
+type RateLimitRule struct {
+	Requests int      `yaml:"requests" toml:"requests"`
+	Per      Duration `yaml:"per" toml:"per"`
+	Burst    int      `yaml:"burst,omitempty" toml:"burst,omitempty"`
+}
+
+// Proxies parses TrustedProxies; single addresses become one-address ranges
+func (c RateLimitConfig) Proxies() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, s := range c.TrustedProxies {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("rate_limit.trusted_proxies: %w", err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("rate_limit.trusted_proxies: %w", err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ConcurrencyConfig describes the adaptive limit on requests served at once,
+// which sheds load before requests queue for a database connection
+type ConcurrencyConfig struct {
+	// InitialLimit is the limit at startup, usually the connection pool size
+	InitialLimit int `yaml:"initial_limit" toml:"initial_limit"`
+	MinLimit     int `yaml:"min_limit" toml:"min_limit"`
+	// MaxLimit bounds the limit; 0 disables load shedding
+	MaxLimit int `yaml:"max_limit" toml:"max_limit"`
+	// Tolerance is how many times the fastest recent latency a request may
+	// take before the limit is lowered
+	Tolerance float64 `yaml:"tolerance" toml:"tolerance"`
+	// BaselineWindow is how long the fastest latency is remembered
+	BaselineWindow Duration `yaml:"baseline_window" toml:"baseline_window"`
+	// RetryAfter is suggested to shed clients
+	RetryAfter Duration `yaml:"retry_after" toml:"retry_after"`
+}
+
+// HealthConfig describes the readiness checks
+type HealthConfig struct {
+	// Timeout bounds each check, such as a database ping
+	Timeout Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// TracingConfig describes OpenTelemetry tracing
+type TracingConfig struct {
+	// Exporter is "none", "stdout" or "otlp-file". With none, spans are not
+	// exported but still give logs their trace IDs and are propagated.
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// File receives the spans of otlp-file as OTLP/JSON, one batch per line
+	File string `yaml:"file" toml:"file"`
+	// SampleRatio is the fraction of new traces that is recorded; requests
+	// with a traceparent header follow the caller's decision
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+	// ServiceName defaults to the name of the program
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+}
+
+// ServerTimingConfig describes the Server-Timing response header
+type ServerTimingConfig struct {
+	// Enabled sends the header on every response; otherwise only admins
+	// get it, by sending X-Debug-Timing
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// QueryThreshold is the number of queries in one request above which a
+	// likely N+1 pattern is flagged and logged; 0 disables the check
+	QueryThreshold int `yaml:"query_threshold" toml:"query_threshold"`
+}
+
+// SlowQueryConfig describes the slow-query log and the per-shape query
+// statistics
+type SlowQueryConfig struct {
+	// Threshold is how long a statement may take before it is logged; 0
+	// disables the log but keeps the statistics
+	Threshold Duration `yaml:"threshold" toml:"threshold"`
+	// Explain runs EXPLAIN in the background on the first slow statement of
+	// each shape and records its plan
+	Explain bool `yaml:"explain" toml:"explain"`
+	// MaxShapes bounds the number of statement shapes with statistics
+	MaxShapes int `yaml:"max_shapes" toml:"max_shapes"`
+}
+
+// Defaults returns the built-in configuration, matching the local
+// development database the programs used before configuration existed.
+func Defaults() Config {
+	return Config{
+		Database: DatabaseConfig{
+			Host:            "127.0.0.1",
+			Port:            3306,
+			User:            "root",
+			Password:        "password",
+			Name:            "gocon",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: Duration{5 * time.Minute},
+			ConnectTimeout:  Duration{10 * time.Second},
+			ReadTimeout:     Duration{30 * time.Second},
+			WriteTimeout:    Duration{30 * time.Second},
+			Timeouts: QueryTimeouts{
+				List:      Duration{10 * time.Second},
+				Get:       Duration{3 * time.Second},
+				Write:     Duration{5 * time.Second},
+				Migration: Duration{2 * time.Minute},
+			},
+		},
+		Server: ServerConfig{
+			Addr:              ":8080",
+			ReadTimeout:       Duration{15 * time.Second},
+			ReadHeaderTimeout: Duration{5 * time.Second},
+			WriteTimeout:      Duration{30 * time.Second},
+			IdleTimeout:       Duration{60 * time.Second},
+			ShutdownTimeout:   Duration{20 * time.Second},
+			MaxHeaderBytes:    1 << 20,
+			MaxBodyBytes:      1 << 20,
+		},
+		Log: LogConfig{Level: "info", Format: "text"},
+		Auth: AuthConfig{
+			AccessTokenTTL:  Duration{15 * time.Minute},
+			RefreshTokenTTL: Duration{30 * 24 * time.Hour},
+			TOTPIssuer:      "GoLang REST API",
+		},
+		RateLimit: RateLimitConfig{
+			Default: RateLimitRule{Requests: 300, Per: Duration{time.Minute}},
+			// Slow down password and one-time code guessing
+			Routes: map[string]RateLimitRule{
+				"POST /auth/login":    {Requests: 10, Per: Duration{time.Minute}},
+				"POST /auth/register": {Requests: 5, Per: Duration{time.Minute}},
+			},
+		},
+		Concurrency: ConcurrencyConfig{
+			InitialLimit:   25,
+			MinLimit:       5,
+			MaxLimit:       100,
+			Tolerance:      2,
+			BaselineWindow: Duration{time.Minute},
+			RetryAfter:     Duration{time.Second},
+		},
+		Health:       HealthConfig{Timeout: Duration{2 * time.Second}},
+		Tracing:      TracingConfig{Exporter: "none", File: "traces.jsonl", SampleRatio: 1},
+		ServerTiming: ServerTimingConfig{QueryThreshold: 10},
+		SlowQuery:    SlowQueryConfig{Threshold: Duration{200 * time.Millisecond}, MaxShapes: 500},
+	}
+}
+
+// DSN returns the go-sql-driver/mysql data source name. It uses utf8mb4,
+// parses DATETIME columns into time.Time and uses the local time zone, as
+// the GORM programs always did.
+func (d DatabaseConfig) DSN() string {
+	return d.mysqlConfig().FormatDSN()
+}
+
+// Connector returns a go-sql-driver/mysql connector for the same settings
+// as DSN, for wrapping before it is opened with sql.OpenDB
+func (d DatabaseConfig) Connector() (driver.Connector, error) {
+	return mysql.NewConnector(d.mysqlConfig())
+}
+
+func (d DatabaseConfig) mysqlConfig() *mysql.Config {
+	c := mysql.NewConfig()
+	c.User = d.User
+	c.Passwd = d.Password.Value()
+	c.Net = "tcp"
+	c.Addr = net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+	c.DBName = d.Name
+	c.ParseTime = true
+	c.Loc = time.Local
+	c.Timeout = d.ConnectTimeout.Duration
+	c.ReadTimeout = d.ReadTimeout.Duration
+	c.WriteTimeout = d.WriteTimeout.Duration
+	c.Params = map[string]string{"charset": "utf8mb4"}
+	return c
+}
+
+// ApplyPool applies the connection pool settings to db
+func (d DatabaseConfig) ApplyPool(db *sql.DB) {
+	db.SetMaxOpenConns(d.MaxOpenConns)
+	db.SetMaxIdleConns(d.MaxIdleConns)
+	db.SetConnMaxLifetime(d.ConnMaxLifetime.Duration)
+}
+
+// Validate reports the first invalid setting
+func (c Config) Validate() error {
+	switch {
+	case c.Database.Host == "":
+		return fmt.Errorf("database.host must be set")
+	case c.Database.Port <= 0 || c.Database.Port > 65535:
+		return fmt.Errorf("database.port %d is out of range", c.Database.Port)
+	case c.Database.Name == "":
+		return fmt.Errorf("database.name must be set")
+	case c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0:
+		return fmt.Errorf("database pool sizes must not be negative")
+	case c.Database.Timeouts.List.Duration < 0 || c.Database.Timeouts.Get.Duration < 0 ||
+		c.Database.Timeouts.Write.Duration < 0 || c.Database.Timeouts.Migration.Duration < 0:
+		return fmt.Errorf("database.timeouts must not be negative")
+	case c.Server.Addr == "":
+		return fmt.Errorf("server.addr must be set")
+	case c.Server.MaxHeaderBytes < 0 || c.Server.MaxBodyBytes < 0:
+		return fmt.Errorf("server size limits must not be negative")
+	case c.Auth.AccessTokenTTL.Duration <= 0 || c.Auth.RefreshTokenTTL.Duration <= 0:
+		return fmt.Errorf("auth token lifetimes must be positive")
+	case c.Auth.BootstrapAdmin != "" && len(c.Auth.BootstrapAdminPassword.Value()) < 8:
+		return fmt.Errorf("auth.bootstrap_admin_password must have at least 8 characters")
+	case c.Health.Timeout.Duration <= 0:
+		return fmt.Errorf("health.timeout must be positive")
+	case c.ServerTiming.QueryThreshold < 0:
+		return fmt.Errorf("server_timing.query_threshold must not be negative")
+	case c.SlowQuery.Threshold.Duration < 0:
+		return fmt.Errorf("slow_query.threshold must not be negative")
+	case c.SlowQuery.MaxShapes < 1:
+		return fmt.Errorf("slow_query.max_shapes must be positive")
+	}
+	if err := c.RateLimit.validate(); err != nil {
+		return err
+	}
+	if cc := c.Concurrency; cc.MaxLimit > 0 {
+		switch {
+		case cc.MinLimit < 1 || cc.MinLimit > cc.MaxLimit:
+			return fmt.Errorf("concurrency.min_limit must be between 1 and max_limit")
+		case cc.InitialLimit < cc.MinLimit || cc.InitialLimit > cc.MaxLimit:
+			return fmt.Errorf("concurrency.initial_limit must be between min_limit and max_limit")
+		case cc.Tolerance <= 1:
+			return fmt.Errorf("concurrency.tolerance must be greater than 1")
+		case cc.BaselineWindow.Duration <= 0:
+			return fmt.Errorf("concurrency.baseline_window must be positive")
+		}
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log.level %q must be one of debug, info, warn, error", c.Log.Level)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		return fmt.Errorf("log.format %q must be text or json", c.Log.Format)
+	}
+	switch t := c.Tracing; {
+	case t.Exporter != "none" && t.Exporter != "stdout" && t.Exporter != "otlp-file":
+		return fmt.Errorf("tracing.exporter %q must be one of none, stdout, otlp-file", t.Exporter)
+	case t.Exporter == "otlp-file" && t.File == "":
+		return fmt.Errorf("tracing.file must be set for the otlp-file exporter")
+	case t.SampleRatio < 0 || t.SampleRatio > 1:
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+func (c RateLimitConfig) validate() error {
+	check := func(name string, r RateLimitRule) error {
+		if r.Requests < 0 || r.Burst < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+		if r.Requests > 0 && r.Per.Duration <= 0 {
+			return fmt.Errorf("%s.per must be positive", name)
+		}
+		return nil
+	}
+	if err := check("rate_limit.default", c.Default); err != nil {
+		return err
+	}
+	for route, r := range c.Routes {
+		if err := check(fmt.Sprintf("rate_limit.routes[%q]", route), r); err != nil {
+			return err
+		}
+	}
+	_, err := c.Proxies()
+	return err
+}
+
+// Duration is a time.Duration written as a string such as "30s" in files,
+// environment variables and flags.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Secret is a string that is redacted whenever it is printed or marshalled.
+// Use Value to get the actual secret.
+type Secret string
+
+const redacted = "[REDACTED]"
+
+// Value returns the secret itself
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Secret) UnmarshalText(text []byte) error {
+	*s = Secret(text)
+	return nil
+}
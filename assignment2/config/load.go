@@ -0,0 +1,256 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// setting binds one configuration value to its environment variable and flag
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	value func(c *Config) interface{} // pointer to the field in c
+}
+
+var settings = []setting{
+	{"DB_HOST", "db-host", "database host", func(c *Config) interface{} { return &c.Database.Host }},
+	{"DB_PORT", "db-port", "database port", func(c *Config) interface{} { return &c.Database.Port }},
+	{"DB_USER", "db-user", "database user", func(c *Config) interface{} { return &c.Database.User }},
+	{"DB_PASSWORD", "db-password", "database password", func(c *Config) interface{} { return &c.Database.Password }},
+	{"DB_PASSWORD_FILE", "db-password-file", "file containing the database password", func(c *Config) interface{} { return &c.Database.PasswordFile }},
+	{"DB_NAME", "db-name", "database name", func(c *Config) interface{} { return &c.Database.Name }},
+	{"DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open connections in the pool", func(c *Config) interface{} { return &c.Database.MaxOpenConns }},
+	{"DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle connections in the pool", func(c *Config) interface{} { return &c.Database.MaxIdleConns }},
+	{"DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a pooled connection", func(c *Config) interface{} { return &c.Database.ConnMaxLifetime }},
+	{"DB_CONNECT_TIMEOUT", "db-connect-timeout", "timeout for establishing a connection", func(c *Config) interface{} { return &c.Database.ConnectTimeout }},
+	{"DB_READ_TIMEOUT", "db-read-timeout", "I/O read timeout", func(c *Config) interface{} { return &c.Database.ReadTimeout }},
+	{"DB_WRITE_TIMEOUT", "db-write-timeout", "I/O write timeout", func(c *Config) interface{} { return &c.Database.WriteTimeout }},
+	{"DB_TIMEOUT_LIST", "db-timeout-list", "deadline of list and count queries (0 disables)", func(c *Config) interface{} { return &c.Database.Timeouts.List }},
+	{"DB_TIMEOUT_GET", "db-timeout-get", "deadline of single-record reads (0 disables)", func(c *Config) interface{} { return &c.Database.Timeouts.Get }},
+	{"DB_TIMEOUT_WRITE", "db-timeout-write", "deadline of inserts, updates and deletes (0 disables)", func(c *Config) interface{} { return &c.Database.Timeouts.Write }},
+	{"DB_TIMEOUT_MIGRATION", "db-timeout-migration", "deadline of the schema migration at startup (0 disables)", func(c *Config) interface{} { return &c.Database.Timeouts.Migration }},
+	{"LISTEN_ADDR", "listen-addr", "HTTP listen address", func(c *Config) interface{} { return &c.Server.Addr }},
+	{"SERVER_READ_TIMEOUT", "read-timeout", "maximum time to read a whole request", func(c *Config) interface{} { return &c.Server.ReadTimeout }},
+	{"SERVER_READ_HEADER_TIMEOUT", "read-header-timeout", "maximum time to read request headers", func(c *Config) interface{} { return &c.Server.ReadHeaderTimeout }},
+	{"SERVER_WRITE_TIMEOUT", "write-timeout", "maximum time to write a response", func(c *Config) interface{} { return &c.Server.WriteTimeout }},
+	{"SERVER_IDLE_TIMEOUT", "idle-timeout", "keep-alive idle timeout", func(c *Config) interface{} { return &c.Server.IdleTimeout }},
+	{"SERVER_SHUTDOWN_TIMEOUT", "shutdown-timeout", "time allowed for in-flight requests on shutdown", func(c *Config) interface{} { return &c.Server.ShutdownTimeout }},
+	{"SERVER_MAX_HEADER_BYTES", "max-header-bytes", "maximum size of request headers", func(c *Config) interface{} { return &c.Server.MaxHeaderBytes }},
+	{"SERVER_MAX_BODY_BYTES", "max-body-bytes", "maximum size of a request body", func(c *Config) interface{} { return &c.Server.MaxBodyBytes }},
+	{"LOG_LEVEL", "log-level", "log level (debug, info, warn, error)", func(c *Config) interface{} { return &c.Log.Level }},
+	{"LOG_FORMAT", "log-format", "log format (text, json)", func(c *Config) interface{} { return &c.Log.Format }},
+	{"CURSOR_SECRET", "cursor-secret", "key signing pagination cursors", func(c *Config) interface{} { return &c.CursorSecret }},
+	{"CURSOR_SECRET_FILE", "cursor-secret-file", "file containing the cursor signing key", func(c *Config) interface{} { return &c.CursorSecretFile }},
+	{"AUTH_SECRET", "auth-secret", "key signing access tokens", func(c *Config) interface{} { return &c.Auth.Secret }},
+	{"AUTH_SECRET_FILE", "auth-secret-file", "file containing the access token signing key", func(c *Config) interface{} { return &c.Auth.SecretFile }},
+	{"AUTH_ACCESS_TOKEN_TTL", "access-token-ttl", "lifetime of access tokens", func(c *Config) interface{} { return &c.Auth.AccessTokenTTL }},
+	{"AUTH_REFRESH_TOKEN_TTL", "refresh-token-ttl", "lifetime of refresh tokens", func(c *Config) interface{} { return &c.Auth.RefreshTokenTTL }},
+	{"AUTH_TOTP_ISSUER", "totp-issuer", "service name shown in authenticator apps", func(c *Config) interface{} { return &c.Auth.TOTPIssuer }},
+	{"AUTH_BOOTSTRAP_ADMIN", "bootstrap-admin", "name of an admin account created at startup", func(c *Config) interface{} { return &c.Auth.BootstrapAdmin }},
+	{"AUTH_BOOTSTRAP_ADMIN_PASSWORD", "bootstrap-admin-password", "password of the bootstrap admin account", func(c *Config) interface{} { return &c.Auth.BootstrapAdminPassword }},
+	{"AUTH_BOOTSTRAP_ADMIN_PASSWORD_FILE", "bootstrap-admin-password-file", "file containing the password of the bootstrap admin account", func(c *Config) interface{} { return &c.Auth.BootstrapAdminPasswordFile }},
+	{"RATE_LIMIT_REQUESTS", "rate-limit-requests", "requests per client and period on routes without their own limit (0 disables)", func(c *Config) interface{} { return &c.RateLimit.Default.Requests }},
+	{"RATE_LIMIT_PER", "rate-limit-per", "period of the default rate limit", func(c *Config) interface{} { return &c.RateLimit.Default.Per }},
+	{"RATE_LIMIT_BURST", "rate-limit-burst", "burst size of the default rate limit", func(c *Config) interface{} { return &c.RateLimit.Default.Burst }},
+	{"TRUSTED_PROXIES", "trusted-proxies", "comma-separated addresses or CIDR ranges of trusted reverse proxies", func(c *Config) interface{} { return &c.RateLimit.TrustedProxies }},
+	{"CONCURRENCY_INITIAL_LIMIT", "concurrency-initial-limit", "requests served at once at startup", func(c *Config) interface{} { return &c.Concurrency.InitialLimit }},
+	{"CONCURRENCY_MIN_LIMIT", "concurrency-min-limit", "lowest adaptive concurrency limit", func(c *Config) interface{} { return &c.Concurrency.MinLimit }},
+	{"CONCURRENCY_MAX_LIMIT", "concurrency-max-limit", "highest adaptive concurrency limit (0 disables load shedding)", func(c *Config) interface{} { return &c.Concurrency.MaxLimit }},
+	{"CONCURRENCY_TOLERANCE", "concurrency-tolerance", "latency, as a multiple of the fastest, that lowers the limit", func(c *Config) interface{} { return &c.Concurrency.Tolerance }},
+	{"HEALTH_TIMEOUT", "health-timeout", "timeout of each readiness check", func(c *Config) interface{} { return &c.Health.Timeout }},
+	{"TRACING_EXPORTER", "tracing-exporter", "trace exporter (none, stdout, otlp-file)", func(c *Config) interface{} { return &c.Tracing.Exporter }},
+	{"TRACING_FILE", "tracing-file", "file receiving OTLP/JSON spans", func(c *Config) interface{} { return &c.Tracing.File }},
+	{"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "fraction of new traces recorded", func(c *Config) interface{} { return &c.Tracing.SampleRatio }},
+	{"TRACING_SERVICE_NAME", "tracing-service-name", "service name reported with spans", func(c *Config) interface{} { return &c.Tracing.ServiceName }},
+	{"SERVER_TIMING", "server-timing", "send the Server-Timing header on every response", func(c *Config) interface{} { return &c.ServerTiming.Enabled }},
+	{"SERVER_TIMING_QUERY_THRESHOLD", "server-timing-query-threshold", "queries per request flagged as a likely N+1 pattern (0 disables)", func(c *Config) interface{} { return &c.ServerTiming.QueryThreshold }},
+	{"SLOW_QUERY_THRESHOLD", "slow-query-threshold", "duration above which statements are logged as slow (0 disables)", func(c *Config) interface{} { return &c.SlowQuery.Threshold }},
+	{"SLOW_QUERY_EXPLAIN", "slow-query-explain", "record the EXPLAIN plan of slow statements", func(c *Config) interface{} { return &c.SlowQuery.Explain }},
+	{"SLOW_QUERY_MAX_SHAPES", "slow-query-max-shapes", "number of statement shapes with statistics", func(c *Config) interface{} { return &c.SlowQuery.MaxShapes }},
+}
+
+// Stores the string s into the field pointed to by dst
+func assign(dst interface{}, s string) error {
+	switch v := dst.(type) {
+	case *string:
+		*v = s
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case encoding.TextUnmarshaler:
+		return v.UnmarshalText([]byte(s))
+	default:
+		return fmt.Errorf("unsupported setting type %T", dst)
+	}
+	return nil
+}
+
+// Load builds the configuration for the program name from args (usually
+// os.Args[1:]). It returns the arguments left after the flags.
+func Load(name string, args []string) (Config, []string, error) {
+	cfg := Defaults()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	flagValues := make([]*string, len(settings))
+	for i, s := range settings {
+		flagValues[i] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [config print]\n", name)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(&cfg, *configFile); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := assign(s.value(&cfg), v); err != nil {
+				return cfg, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for i, s := range settings {
+		if set[s.flag] {
+			if err := assign(s.value(&cfg), *flagValues[i]); err != nil {
+				return cfg, nil, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := readSecretFile(&cfg.Database.Password, cfg.Database.PasswordFile); err != nil {
+		return cfg, nil, err
+	}
+	if err := readSecretFile(&cfg.CursorSecret, cfg.CursorSecretFile); err != nil {
+		return cfg, nil, err
+	}
+	if err := readSecretFile(&cfg.Auth.Secret, cfg.Auth.SecretFile); err != nil {
+		return cfg, nil, err
+	}
+	if err := readSecretFile(&cfg.Auth.BootstrapAdminPassword, cfg.Auth.BootstrapAdminPasswordFile); err != nil {
+		return cfg, nil, err
+	}
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+// Decodes a YAML or TOML file, chosen by extension, over cfg
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && err != io.EOF {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Replaces *dst with the contents of path, if path is set. A single trailing
+// newline is trimmed, as secret files usually end with one.
+func readSecretFile(dst *Secret, path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading secret file: %w", err)
+	}
+	*dst = Secret(strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"))
+	return nil
+}
+
+// Print writes cfg as YAML with all secrets redacted
+func Print(w io.Writer, cfg Config) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// MustLoad loads the configuration from the command line and environment,
+// exiting on error. When the remaining arguments are "config print", it
+// prints the effective configuration and exits.
+func MustLoad() Config {
+	name := filepath.Base(os.Args[0])
+	cfg, args, err := Load(name, os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if len(args) == 2 && args[0] == "config" && args[1] == "print" {
+		if err := Print(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	return cfg
+}
@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"log"
 
+	"assignment2/config"
+
 	_ "github.com/go-sql-driver/mysql"
 )
 
 func main() {
+	cfg := config.MustLoad()
 
-	db, err := sql.Open("mysql", "root:password@tcp(127.0.0.1:3306)/gocon")
+	db, err := sql.Open("mysql", cfg.Database.DSN())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,151 @@
+// Package dberr classifies MySQL errors, as returned by go-sql-driver/mysql
+// directly or wrapped by GORM, into the domain errors of package apperr.
+package dberr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+
+	"assignment2/apperr"
+)
+
+// MySQL server error numbers
+const (
+	ErDupEntry        = 1062
+	ErBadNull         = 1048
+	ErLockWaitTimeout = 1205
+	ErLockDeadlock    = 1213
+	ErOutOfRange      = 1264
+	ErDataTooLong     = 1406
+	ErRowIsReferenced = 1451
+	ErNoReferencedRow = 1452
+	// Pre-5.5 equivalents of ErRowIsReferenced and ErNoReferencedRow
+	ErRowIsReferencedOld = 1217
+	ErNoReferencedRowOld = 1216
+	// The statement ran longer than max_execution_time
+	ErQueryTimeout = 3024
+)
+
+var (
+	// Duplicate entry 'Bob' for key 'users.name'
+	dupKeyRe = regexp.MustCompile(`for key '([^']+)'`)
+	// ... FOREIGN KEY (`user_id`) REFERENCES ...
+	foreignKeyRe = regexp.MustCompile("FOREIGN KEY \\(`([^`]+)`\\)")
+	// Data too long for column 'name' at row 1, Column 'age' cannot be null
+	columnRe = regexp.MustCompile(`(?i)column '([^']+)'`)
+)
+
+// Classify returns the domain error for err. MySQL errors it recognises
+// become conflict, validation or unavailable errors that keep the driver
+// error as their cause, and errors of a canceled or expired context become
+// canceled or timeout errors; other errors are returned unchanged.
+func Classify(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled):
+		return apperr.Canceled("the request was canceled", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return apperr.Timeout("the database did not answer in time", err)
+	}
+
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		// GORM only returns these when TranslateError is enabled
+		switch {
+		case errors.Is(err, gorm.ErrDuplicatedKey):
+			return &apperr.Error{Code: apperr.CodeConflict, Message: "a record with the same unique value already exists", Err: err}
+		case errors.Is(err, gorm.ErrForeignKeyViolated):
+			return &apperr.Error{Code: apperr.CodeConflict, Message: "the change violates a reference to another record", Err: err}
+		}
+		return err
+	}
+
+	switch myErr.Number {
+	case ErDupEntry:
+		field := uniqueKeyField(myErr.Message)
+		return &apperr.Error{
+			Code:    apperr.CodeConflict,
+			Message: fmt.Sprintf("a record with this %s already exists", field),
+			Fields:  []apperr.FieldError{{Field: field, Message: "must be unique"}},
+			Err:     err,
+		}
+	case ErNoReferencedRow, ErNoReferencedRowOld:
+		field := submatch(foreignKeyRe, myErr.Message)
+		return &apperr.Error{
+			Code:    apperr.CodeValidation,
+			Message: "the referenced record does not exist",
+			Fields:  []apperr.FieldError{{Field: field, Message: "must reference an existing record"}},
+			Err:     err,
+		}
+	case ErRowIsReferenced, ErRowIsReferencedOld:
+		return &apperr.Error{
+			Code:    apperr.CodeConflict,
+			Message: "the record is still referenced by other records",
+			Err:     err,
+		}
+	case ErDataTooLong, ErBadNull, ErOutOfRange:
+		field := submatch(columnRe, myErr.Message)
+		message := map[uint16]string{
+			ErDataTooLong: "is too long",
+			ErBadNull:     "is required",
+			ErOutOfRange:  "is out of range",
+		}[myErr.Number]
+		return &apperr.Error{
+			Code:    apperr.CodeValidation,
+			Message: "The request body is invalid",
+			Fields:  []apperr.FieldError{{Field: field, Message: message}},
+			Err:     err,
+		}
+	case ErLockDeadlock, ErLockWaitTimeout:
+		return apperr.Unavailable("the database is busy, please retry", err)
+	case ErQueryTimeout:
+		return apperr.Timeout("the database did not answer in time", err)
+	}
+	return err
+}
+
+// IsRetryable reports whether err is a deadlock or lock wait timeout, after
+// which the whole transaction can be run again.
+func IsRetryable(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == ErLockDeadlock || myErr.Number == ErLockWaitTimeout
+	}
+	return false
+}
+
+// Derives the field name from a unique key name such as "users.name",
+// "name", "uni_users_name" or "idx_users_name"
+func uniqueKeyField(message string) string {
+	key := submatch(dupKeyRe, message)
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	for _, prefix := range []string{"uni_", "idx_", "uniq_"} {
+		if rest, ok := strings.CutPrefix(key, prefix); ok {
+			// GORM names indexes <prefix><table>_<column>
+			if _, column, ok := strings.Cut(rest, "_"); ok {
+				return column
+			}
+			return rest
+		}
+	}
+	if key == "" || key == "PRIMARY" {
+		return "id"
+	}
+	return key
+}
+
+func submatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package dberr_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+
+	"assignment2/apperr"
+	"assignment2/dberr"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantFields []apperr.FieldError
+	}{
+		{
+			name:       "duplicate entry",
+			err:        &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'bob' for key 'users.name'"},
+			wantStatus: http.StatusConflict,
+			wantFields: []apperr.FieldError{{Field: "name", Message: "must be unique"}},
+		},
+		{
+			name:       "duplicate entry on a GORM index",
+			err:        &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'profiles.idx_profiles_email'"},
+			wantStatus: http.StatusConflict,
+			wantFields: []apperr.FieldError{{Field: "email", Message: "must be unique"}},
+		},
+		{
+			name:       "duplicate primary key",
+			err:        &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"},
+			wantStatus: http.StatusConflict,
+			wantFields: []apperr.FieldError{{Field: "id", Message: "must be unique"}},
+		},
+		{
+			name:       "missing referenced row",
+			err:        &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`app`.`profiles`, CONSTRAINT `fk_users_profile` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []apperr.FieldError{{Field: "user_id", Message: "must reference an existing record"}},
+		},
+		{
+			name:       "missing referenced row, old number",
+			err:        &mysql.MySQLError{Number: 1216, Message: "Cannot add or update a child row: a foreign key constraint fails"},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []apperr.FieldError{{Field: "", Message: "must reference an existing record"}},
+		},
+		{
+			name:       "row still referenced",
+			err:        &mysql.MySQLError{Number: 1451, Message: "Cannot delete or update a parent row: a foreign key constraint fails"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "row still referenced, old number",
+			err:        &mysql.MySQLError{Number: 1217, Message: "Cannot delete or update a parent row: a foreign key constraint fails"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "data too long",
+			err:        &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'name' at row 1"},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []apperr.FieldError{{Field: "name", Message: "is too long"}},
+		},
+		{
+			name:       "null",
+			err:        &mysql.MySQLError{Number: 1048, Message: "Column 'age' cannot be null"},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []apperr.FieldError{{Field: "age", Message: "is required"}},
+		},
+		{
+			name:       "out of range",
+			err:        &mysql.MySQLError{Number: 1264, Message: "Out of range value for column 'age' at row 1"},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []apperr.FieldError{{Field: "age", Message: "is out of range"}},
+		},
+		{
+			name:       "deadlock",
+			err:        &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "lock wait timeout",
+			err:        &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "query timeout",
+			err:        &mysql.MySQLError{Number: 3024, Message: "Query execution was interrupted, maximum statement execution time exceeded"},
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "other MySQL error",
+			err:        &mysql.MySQLError{Number: 1146, Message: "Table 'app.users' doesn't exist"},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "wrapped by GORM",
+			err:        fmt.Errorf("create user: %w", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'bob' for key 'uni_users_name'"}),
+			wantStatus: http.StatusConflict,
+			wantFields: []apperr.FieldError{{Field: "name", Message: "must be unique"}},
+		},
+		{
+			name:       "translated by GORM",
+			err:        gorm.ErrDuplicatedKey,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "foreign key translated by GORM",
+			err:        gorm.ErrForeignKeyViolated,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "canceled",
+			err:        fmt.Errorf("query: %w", context.Canceled),
+			wantStatus: apperr.StatusClientClosedRequest,
+		},
+		{
+			name:       "deadline",
+			err:        context.DeadlineExceeded,
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "unknown",
+			err:        errors.New("invalid connection"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dberr.Classify(tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Classify dropped the cause %v: %v", tt.err, err)
+			}
+			domain := apperr.From(err)
+			if status := domain.Code.Status(); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(domain.Fields, tt.wantFields) {
+				t.Errorf("fields = %+v, want %+v", domain.Fields, tt.wantFields)
+			}
+		})
+	}
+
+	if err := dberr.Classify(nil); err != nil {
+		t.Errorf("Classify(nil) = %v", err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&mysql.MySQLError{Number: 1213}, true},
+		{&mysql.MySQLError{Number: 1205}, true},
+		{fmt.Errorf("commit: %w", &mysql.MySQLError{Number: 1213}), true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{context.DeadlineExceeded, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := dberr.IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
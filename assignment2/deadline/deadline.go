@@ -0,0 +1,223 @@
+// Package deadline bounds repository operations. Each operation runs under
+// the context of its request, so that a client disconnecting cancels its
+// queries, narrowed by the configured deadline for its kind: list, get or
+// write. Operations that end with their context fail with a canceled or
+// timeout error of package apperr instead of an internal error.
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"assignment2/apperr"
+	"assignment2/config"
+	"assignment2/dberr"
+	"assignment2/models"
+	"assignment2/querybuilder"
+	"assignment2/repository"
+)
+
+// Users wraps repo so that each operation gets the deadline of its kind
+func Users(repo repository.UserRepository, timeouts config.QueryTimeouts) repository.UserRepository {
+	return &users{repo: repo, timeouts: timeouts}
+}
+
+// Tokens is Users for a TokenRepository
+func Tokens(repo repository.TokenRepository, timeouts config.QueryTimeouts) repository.TokenRepository {
+	return &tokens{repo: repo, timeouts: timeouts}
+}
+
+// Context returns ctx with the given timeout, or ctx itself if the timeout
+// is 0
+func Context(ctx context.Context, timeout config.Duration) (context.Context, context.CancelFunc) {
+	if timeout.Duration > 0 {
+		return context.WithTimeout(ctx, timeout.Duration)
+	}
+	return ctx, func() {}
+}
+
+// Derives the context of one operation. The returned function cancels it;
+// if the operation failed after its context ended, it blames the context,
+// since the driver may report a canceled query as a broken connection.
+func start(ctx context.Context, timeout config.Duration) (context.Context, func(*error)) {
+	ctx, cancel := Context(ctx, timeout)
+	return ctx, func(err *error) {
+		if *err != nil && ctx.Err() != nil && apperr.From(*err).Code == apperr.CodeInternal {
+			*err = dberr.Classify(fmt.Errorf("%w: %w", ctx.Err(), *err))
+		}
+		cancel()
+	}
+}
+
+type users struct {
+	repo     repository.UserRepository
+	timeouts config.QueryTimeouts
+}
+
+func (r *users) Create(ctx context.Context, user *models.User) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.Create(ctx, user)
+}
+
+func (r *users) Get(ctx context.Context, id uint) (_ models.User, err error) {
+	ctx, done := start(ctx, r.timeouts.Get)
+	defer done(&err)
+	return r.repo.Get(ctx, id)
+}
+
+func (r *users) GetByName(ctx context.Context, name string) (_ models.User, err error) {
+	ctx, done := start(ctx, r.timeouts.Get)
+	defer done(&err)
+	return r.repo.GetByName(ctx, name)
+}
+
+func (r *users) List(ctx context.Context, params querybuilder.ListParams, limit, offset int) (_ []models.User, err error) {
+	ctx, done := start(ctx, r.timeouts.List)
+	defer done(&err)
+	return r.repo.List(ctx, params, limit, offset)
+}
+
+func (r *users) Count(ctx context.Context, params querybuilder.ListParams) (_ int64, err error) {
+	ctx, done := start(ctx, r.timeouts.List)
+	defer done(&err)
+	return r.repo.Count(ctx, params)
+}
+
+func (r *users) Update(ctx context.Context, id uint, update repository.UserUpdate) (_ models.User, err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.Update(ctx, id, update)
+}
+
+func (r *users) Delete(ctx context.Context, id uint) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.Delete(ctx, id)
+}
+
+func (r *users) SetRole(ctx context.Context, id uint, role string) (_ models.User, err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.SetRole(ctx, id, role)
+}
+
+func (r *users) GetProfile(ctx context.Context, userID uint) (_ models.Profile, err error) {
+	ctx, done := start(ctx, r.timeouts.Get)
+	defer done(&err)
+	return r.repo.GetProfile(ctx, userID)
+}
+
+func (r *users) SaveProfile(ctx context.Context, userID uint, profile models.Profile) (_ models.Profile, err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.SaveProfile(ctx, userID, profile)
+}
+
+type tokens struct {
+	repo     repository.TokenRepository
+	timeouts config.QueryTimeouts
+}
+
+func (r *tokens) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.CreateRefreshToken(ctx, token)
+}
+
+func (r *tokens) GetRefreshToken(ctx context.Context, hash string) (_ models.RefreshToken, err error) {
+	ctx, done := start(ctx, r.timeouts.Get)
+	defer done(&err)
+	return r.repo.GetRefreshToken(ctx, hash)
+}
+
+func (r *tokens) RevokeRefreshToken(ctx context.Context, id uint, at time.Time) (_ bool, err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.RevokeRefreshToken(ctx, id, at)
+}
+
+func (r *tokens) RevokeUserRefreshTokens(ctx context.Context, userID uint, at time.Time) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.RevokeUserRefreshTokens(ctx, userID, at)
+}
+
+func (r *tokens) RevokeAccessToken(ctx context.Context, token models.RevokedToken) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.RevokeAccessToken(ctx, token)
+}
+
+func (r *tokens) IsAccessTokenRevoked(ctx context.Context, id string) (_ bool, err error) {
+	ctx, done := start(ctx, r.timeouts.Get)
+	defer done(&err)
+	return r.repo.IsAccessTokenRevoked(ctx, id)
+}
+
+func (r *tokens) CreateAPIKey(ctx context.Context, key *models.APIKey) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.CreateAPIKey(ctx, key)
+}
+
+func (r *tokens) GetAPIKey(ctx context.Context, hash string) (_ models.APIKey, err error) {
+	ctx, done := start(ctx, r.timeouts.Get)
+	defer done(&err)
+	return r.repo.GetAPIKey(ctx, hash)
+}
+
+func (r *tokens) ListAPIKeys(ctx context.Context, userID uint) (_ []models.APIKey, err error) {
+	ctx, done := start(ctx, r.timeouts.List)
+	defer done(&err)
+	return r.repo.ListAPIKeys(ctx, userID)
+}
+
+func (r *tokens) RevokeAPIKey(ctx context.Context, userID, id uint, at time.Time) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.RevokeAPIKey(ctx, userID, id, at)
+}
+
+func (r *tokens) TouchAPIKey(ctx context.Context, id uint, at time.Time) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.TouchAPIKey(ctx, id, at)
+}
+
+func (r *tokens) GetTwoFactor(ctx context.Context, userID uint) (_ models.TwoFactor, err error) {
+	ctx, done := start(ctx, r.timeouts.Get)
+	defer done(&err)
+	return r.repo.GetTwoFactor(ctx, userID)
+}
+
+func (r *tokens) SaveTwoFactor(ctx context.Context, tf *models.TwoFactor) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.SaveTwoFactor(ctx, tf)
+}
+
+func (r *tokens) ConfirmTwoFactor(ctx context.Context, id uint, step int64, at time.Time, codes []models.RecoveryCode) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.ConfirmTwoFactor(ctx, id, step, at, codes)
+}
+
+func (r *tokens) UseTOTPStep(ctx context.Context, id uint, step int64) (_ bool, err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.UseTOTPStep(ctx, id, step)
+}
+
+func (r *tokens) UseRecoveryCode(ctx context.Context, twoFactorID uint, hash string, at time.Time) (_ bool, err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.UseRecoveryCode(ctx, twoFactorID, hash, at)
+}
+
+func (r *tokens) DeleteTwoFactor(ctx context.Context, userID uint) (err error) {
+	ctx, done := start(ctx, r.timeouts.Write)
+	defer done(&err)
+	return r.repo.DeleteTwoFactor(ctx, userID)
+}
@@ -0,0 +1,174 @@
+package deadline_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"assignment2/apperr"
+	"assignment2/config"
+	"assignment2/deadline"
+	"assignment2/models"
+	"assignment2/querybuilder"
+	"assignment2/repository"
+)
+
+// Runs call for the operations the tests use; the others are not implemented
+type fakeUsers struct {
+	repository.UserRepository
+	call func(ctx context.Context) error
+}
+
+func (r fakeUsers) Create(ctx context.Context, user *models.User) error { return r.call(ctx) }
+func (r fakeUsers) Get(ctx context.Context, id uint) (models.User, error) {
+	return models.User{}, r.call(ctx)
+}
+func (r fakeUsers) List(ctx context.Context, params querybuilder.ListParams, limit, offset int) ([]models.User, error) {
+	return nil, r.call(ctx)
+}
+func (r fakeUsers) Count(ctx context.Context, params querybuilder.ListParams) (int64, error) {
+	return 0, r.call(ctx)
+}
+func (r fakeUsers) Delete(ctx context.Context, id uint) error { return r.call(ctx) }
+
+type fakeTokens struct {
+	repository.TokenRepository
+	call func(ctx context.Context) error
+}
+
+func (r fakeTokens) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	return models.APIKey{}, r.call(ctx)
+}
+func (r fakeTokens) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	return nil, r.call(ctx)
+}
+func (r fakeTokens) UseTOTPStep(ctx context.Context, id uint, step int64) (bool, error) {
+	return false, r.call(ctx)
+}
+
+var timeouts = config.QueryTimeouts{
+	List:  config.Duration{Duration: time.Minute},
+	Get:   config.Duration{Duration: 2 * time.Minute},
+	Write: config.Duration{Duration: 3 * time.Minute},
+}
+
+func TestOperationsGetTheTimeoutOfTheirKind(t *testing.T) {
+	var remaining time.Duration
+	record := func(ctx context.Context) error {
+		d, ok := ctx.Deadline()
+		if !ok {
+			t.Error("operation without a deadline")
+		}
+		remaining = time.Until(d)
+		return nil
+	}
+	users := deadline.Users(fakeUsers{call: record}, timeouts)
+	tokens := deadline.Tokens(fakeTokens{call: record}, timeouts)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		op   func() error
+		want time.Duration
+	}{
+		{"list users", func() error { _, err := users.List(ctx, querybuilder.ListParams{}, 10, 0); return err }, time.Minute},
+		{"count users", func() error { _, err := users.Count(ctx, querybuilder.ListParams{}); return err }, time.Minute},
+		{"get user", func() error { _, err := users.Get(ctx, 1); return err }, 2 * time.Minute},
+		{"create user", func() error { return users.Create(ctx, &models.User{}) }, 3 * time.Minute},
+		{"delete user", func() error { return users.Delete(ctx, 1) }, 3 * time.Minute},
+		{"list API keys", func() error { _, err := tokens.ListAPIKeys(ctx, 1); return err }, time.Minute},
+		{"get API key", func() error { _, err := tokens.GetAPIKey(ctx, "hash"); return err }, 2 * time.Minute},
+		{"use TOTP step", func() error { _, err := tokens.UseTOTPStep(ctx, 1, 1); return err }, 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); err != nil {
+				t.Fatal(err)
+			}
+			if remaining > tt.want || remaining < tt.want-time.Second {
+				t.Errorf("deadline in %v, want %v", remaining, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestDeadlineStillApplies(t *testing.T) {
+	var deadlines []time.Time
+	record := func(ctx context.Context) error {
+		d, _ := ctx.Deadline()
+		deadlines = append(deadlines, d)
+		return nil
+	}
+
+	// A zero timeout leaves the operation without a deadline of its own
+	users := deadline.Users(fakeUsers{call: record}, config.QueryTimeouts{})
+	users.Get(context.Background(), 1)
+	if !deadlines[0].IsZero() {
+		t.Errorf("deadline without a timeout = %v", deadlines[0])
+	}
+
+	// An earlier request deadline wins over the operation's
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	deadline.Users(fakeUsers{call: record}, timeouts).Delete(ctx, 1)
+	if !deadlines[1].Equal(want) {
+		t.Errorf("deadline = %v, want the request's %v", deadlines[1], want)
+	}
+}
+
+func TestErrorsAfterTheContextEnds(t *testing.T) {
+	brokenConn := errors.New("invalid connection")
+	// Fails the way the driver does when a query is interrupted
+	interrupted := func(ctx context.Context) error {
+		<-ctx.Done()
+		return brokenConn
+	}
+	short := config.QueryTimeouts{Get: config.Duration{Duration: time.Millisecond}}
+
+	t.Run("deadline", func(t *testing.T) {
+		users := deadline.Users(fakeUsers{call: interrupted}, short)
+		_, err := users.Get(context.Background(), 1)
+		if status := apperr.From(err).Code.Status(); status != http.StatusGatewayTimeout {
+			t.Errorf("status = %d, want %d (err %v)", status, http.StatusGatewayTimeout, err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, brokenConn) {
+			t.Errorf("err = %v, want both the deadline and the driver error", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		users := deadline.Users(fakeUsers{call: func(ctx context.Context) error {
+			cancel()
+			return interrupted(ctx)
+		}}, timeouts)
+		_, err := users.Get(ctx, 1)
+		if status := apperr.From(err).Code.Status(); status != apperr.StatusClientClosedRequest {
+			t.Errorf("status = %d, want %d (err %v)", status, apperr.StatusClientClosedRequest, err)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("domain errors are kept", func(t *testing.T) {
+		users := deadline.Users(fakeUsers{call: func(ctx context.Context) error {
+			<-ctx.Done()
+			return repository.ErrNotFound
+		}}, short)
+		if _, err := users.Get(context.Background(), 1); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("internal errors before the deadline are kept", func(t *testing.T) {
+		users := deadline.Users(fakeUsers{call: func(ctx context.Context) error { return brokenConn }}, timeouts)
+		_, err := users.Get(context.Background(), 1)
+		if err != brokenConn {
+			t.Errorf("err = %v, want the driver error unchanged", err)
+		}
+	})
+}
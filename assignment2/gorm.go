@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 
+	"assignment2/config"
+
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -16,10 +18,17 @@ type User struct {
 }
 
 // Connect to the database
-func connectDatabase() (*gorm.DB, error) {
-	dsn := "root:password@tcp(127.0.0.1:3306)/gocon?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	return db, err
+func connectDatabase(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(mysql.Open(cfg.DSN()), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApplyPool(sqlDB)
+	return db, nil
 }
 
 // Auto migrate the User model
@@ -56,8 +65,10 @@ func queryUsers(db *gorm.DB) {
 }
 
 func main() {
+	cfg := config.MustLoad()
+
 	// Connect to the database
-	db, err := connectDatabase()
+	db, err := connectDatabase(cfg.Database)
 	if err != nil {
 		log.Fatal(err)
 	}
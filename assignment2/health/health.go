@@ -0,0 +1,143 @@
+// Package health serves the liveness and readiness endpoints used by the
+// orchestrator. /healthz only reports that the process serves requests;
+// /readyz runs every registered check, such as pinging a database, and
+// answers 503 when any of them fails so that the instance is taken out of
+// rotation.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"assignment2/apperr"
+	"assignment2/config"
+	"assignment2/logging"
+	"assignment2/redact"
+)
+
+// Status of a check or of the whole instance
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Result is the outcome of one check. Error is only the message of domain
+// errors and timeouts; other errors are logged, not shown.
+type Result struct {
+	Status     string  `json:"status"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Report is the body of a readiness response
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Func checks one dependency
+type Func func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   Func
+}
+
+// Checker runs the readiness checks
+type Checker struct {
+	timeout time.Duration
+	checks  []check
+}
+
+// New returns a checker that gives each check cfg.Timeout
+func New(cfg config.HealthConfig) *Checker {
+	return &Checker{timeout: cfg.Timeout.Duration}
+}
+
+// Add registers a readiness check under name
+func (c *Checker) Add(name string, fn Func) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// Ping checks that db can reach the database
+func Ping(db *sql.DB) Func {
+	return db.PingContext
+}
+
+// Check runs all checks concurrently and reports their results
+func (c *Checker) Check(ctx context.Context) Report {
+	report := Report{Status: StatusUp, Checks: make(map[string]Result, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, ch := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := c.run(ctx, ch)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[ch.name] = result
+			if result.Status != StatusUp {
+				report.Status = StatusDown
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, ch check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	err := ch.fn(ctx)
+	result := Result{Status: StatusUp, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err == nil {
+		return result
+	}
+
+	result.Status = StatusDown
+	var domain *apperr.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil:
+		result.Error = fmt.Sprintf("no answer within %s", c.timeout)
+	case errors.As(err, &domain):
+		result.Error = domain.Detail()
+	default:
+		result.Error = "check failed"
+	}
+	logging.FromContext(ctx).Warn("Readiness check failed", "check", ch.name, "err", redact.Error(err))
+	return result
+}
+
+// Live answers 200 as long as the process serves requests
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": StatusUp})
+}
+
+// Ready runs the checks and answers 200, or 503 if any of them failed, with
+// the Report as body
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Check(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Failed to write health response", "err", err)
+	}
+}
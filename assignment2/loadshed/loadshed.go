@@ -0,0 +1,229 @@
+// Package loadshed limits the number of requests served at once, adapting
+// the limit to the observed latency, and rejects the excess early with a 503
+// instead of letting it queue for a database connection until clients give
+// up.
+//
+// The limit follows AIMD: it grows by one per limit's worth of fast
+// requests while it is in use, and shrinks by a tenth, at most once per
+// round trip, when a request takes Tolerance times longer than the fastest
+// one seen recently on its route. The fastest latency is forgotten every
+// BaselineWindow so that the baseline follows lasting changes.
+//
+// Only requests that a route served successfully are measured: a 404, a
+// rejected credential or a rate-limited request returns long before a
+// database query would, and would otherwise set a baseline that every real
+// request exceeds. Routes keep their own baseline for the same reason, since
+// a password check takes longer than a lookup by ID.
+package loadshed
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"assignment2/apperr"
+	"assignment2/config"
+	"assignment2/problem"
+)
+
+// Factor applied to the limit when latency rises
+const backoff = 0.9
+
+// Stats describes the limiter's current state
+type Stats struct {
+	Limit    int    `json:"limit"`
+	InFlight int    `json:"in_flight"`
+	Shed     uint64 `json:"shed"` // requests rejected since startup
+}
+
+// Limiter sheds the requests over an adaptive concurrency limit
+type Limiter struct {
+	cfg    config.ConcurrencyConfig
+	exempt []string
+	now    func() time.Time
+
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	shed         uint64
+	baselines    map[string]*baseline // by route
+	lastDecrease time.Time
+}
+
+// baseline is the fastest recent latency of a route
+type baseline struct {
+	min time.Duration
+	at  time.Time // when min was last reset
+}
+
+// New returns a limiter for cfg. Requests for one of the exempt paths, such
+// as health checks and metrics, are never limited; like in a ServeMux, a
+// path ending in a slash also exempts everything under it.
+func New(cfg config.ConcurrencyConfig, exempt ...string) *Limiter {
+	return &Limiter{
+		cfg:       cfg,
+		exempt:    exempt,
+		now:       time.Now,
+		limit:     float64(cfg.InitialLimit),
+		baselines: map[string]*baseline{},
+	}
+}
+
+// Stats returns the current limit, the requests in flight and the number of
+// requests shed so far
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{Limit: int(l.limit), InFlight: l.inFlight, Shed: l.shed}
+}
+
+// Middleware sheds requests to next over the limit with a 503
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if l.disabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.isExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start, ok := l.acquire()
+		if !ok {
+			l.reject(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			// The ServeMux records the matched pattern, like "GET /users", in r
+			l.release(start, r.Pattern, rec.status)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// GinMiddleware is Middleware for Gin; use it before registering routes
+func (l *Limiter) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.disabled() || l.isExempt(c.Request) {
+			c.Next()
+			return
+		}
+		start, ok := l.acquire()
+		if !ok {
+			l.reject(c.Writer, c.Request)
+			c.Abort()
+			return
+		}
+		defer func() {
+			route := c.FullPath()
+			if route != "" {
+				route = c.Request.Method + " " + route
+			}
+			l.release(start, route, c.Writer.Status())
+		}()
+		c.Next()
+	}
+}
+
+func (l *Limiter) disabled() bool {
+	return l.cfg.MaxLimit <= 0
+}
+
+func (l *Limiter) isExempt(r *http.Request) bool {
+	for _, path := range l.exempt {
+		if r.URL.Path == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Limiter) reject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.cfg.RetryAfter.Seconds()))))
+	problem.Write(w, r, apperr.Unavailable("the server is overloaded, please retry", nil))
+}
+
+// Admits a request if the limit allows, returning its start time
+func (l *Limiter) acquire() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight >= int(l.limit) {
+		l.shed++
+		return time.Time{}, false
+	}
+	l.inFlight++
+	return l.now(), true
+}
+
+// Records the latency of a finished request on route and adapts the limit
+// to it. Unrouted and unsuccessful requests only free their slot.
+func (l *Limiter) release(start time.Time, route string, status int) {
+	now := l.now()
+	latency := now.Sub(start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	busy := l.inFlight >= int(l.limit)/2
+	l.inFlight--
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if route == "" || status < 200 || status > 299 {
+		return
+	}
+
+	b := l.baselines[route]
+	if b == nil {
+		b = &baseline{}
+		l.baselines[route] = b
+	}
+	if b.min == 0 || latency < b.min || now.Sub(b.at) > l.cfg.BaselineWindow.Duration {
+		if now.Sub(b.at) > l.cfg.BaselineWindow.Duration {
+			b.at = now
+		}
+		b.min = latency
+	}
+
+	switch {
+	case float64(latency) > float64(b.min)*l.cfg.Tolerance:
+		// Requests queue somewhere, most likely for a connection
+		if now.Sub(l.lastDecrease) > latency {
+			l.limit = math.Max(float64(l.cfg.MinLimit), l.limit*backoff)
+			l.lastDecrease = now
+		}
+	case busy:
+		// Only grow a limit that is actually being used
+		l.limit = math.Min(float64(l.cfg.MaxLimit), l.limit+1/l.limit)
+	}
+}
+
+// statusRecorder records the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,194 @@
+package loadshed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"assignment2/config"
+	"assignment2/problem"
+)
+
+// Returns a limiter with the default settings on a clock that only moves
+// when the test says so
+func newTestLimiter(t *testing.T) (*Limiter, *time.Time) {
+	t.Helper()
+	l := New(config.Defaults().Concurrency, "/healthz", "/swagger/")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+// Serves one request on route that takes latency and ends with status
+func serve(t *testing.T, l *Limiter, now *time.Time, route string, status int, latency time.Duration) {
+	t.Helper()
+	start, ok := l.acquire()
+	if !ok {
+		t.Fatalf("request on %s was shed", route)
+	}
+	*now = now.Add(latency)
+	l.release(start, route, status)
+}
+
+func TestLimitShrinksWhenLatencyRises(t *testing.T) {
+	l, now := newTestLimiter(t)
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 2*time.Millisecond)
+
+	// Five times the baseline is over the tolerance of 2
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 10*time.Millisecond)
+	if want := 25 * backoff; l.limit != want {
+		t.Fatalf("limit = %v, want %v", l.limit, want)
+	}
+	// Shrinks at most once per round trip
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 10*time.Millisecond)
+	if want := 25 * backoff; l.limit != want {
+		t.Fatalf("limit after a second slow request = %v, want %v", l.limit, want)
+	}
+	*now = now.Add(time.Millisecond)
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 10*time.Millisecond)
+	if want := 25 * backoff * backoff; l.limit != want {
+		t.Fatalf("limit a round trip later = %v, want %v", l.limit, want)
+	}
+}
+
+func TestLimitStopsAtMinLimit(t *testing.T) {
+	l, now := newTestLimiter(t)
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, time.Millisecond)
+	for i := 0; i < 100; i++ {
+		serve(t, l, now, "GET /users/{id}", http.StatusOK, time.Second)
+	}
+	if got := l.Stats().Limit; got != l.cfg.MinLimit {
+		t.Errorf("limit = %d, want MinLimit %d", got, l.cfg.MinLimit)
+	}
+}
+
+func TestLimitGrowsOnlyWhenBusy(t *testing.T) {
+	l, now := newTestLimiter(t)
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 2*time.Millisecond)
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 2*time.Millisecond)
+	if l.limit != 25 {
+		t.Fatalf("limit after requests one at a time = %v, want 25", l.limit)
+	}
+
+	// With half the limit in flight, each fast request adds 1/limit
+	for i := 0; i < 12; i++ {
+		l.acquire()
+	}
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 2*time.Millisecond)
+	if want := 25 + 1.0/25; l.limit != want {
+		t.Fatalf("limit = %v, want %v", l.limit, want)
+	}
+	for i := 0; i < 30; i++ {
+		serve(t, l, now, "GET /users/{id}", http.StatusOK, 2*time.Millisecond)
+	}
+	if got := l.Stats().Limit; got != 26 {
+		t.Errorf("limit after 31 busy requests = %d, want 26", got)
+	}
+}
+
+func TestLimitIgnoresFastFailures(t *testing.T) {
+	l, now := newTestLimiter(t)
+	// A 404, a rejected key and a rate-limited request, each far faster
+	// than a query
+	serve(t, l, now, "", http.StatusNotFound, 20*time.Microsecond)
+	serve(t, l, now, "GET /users/{id}", http.StatusUnauthorized, 20*time.Microsecond)
+	serve(t, l, now, "GET /users/{id}", http.StatusTooManyRequests, 20*time.Microsecond)
+	for i := 0; i < 30; i++ {
+		*now = now.Add(time.Millisecond)
+		serve(t, l, now, "GET /users/{id}", http.StatusOK, 2*time.Millisecond)
+	}
+	if got := l.Stats().Limit; got != 25 {
+		t.Errorf("limit = %d, want 25", got)
+	}
+}
+
+func TestLimitKeepsBaselinePerRoute(t *testing.T) {
+	l, now := newTestLimiter(t)
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 2*time.Millisecond)
+	// A password check is slow on its own route, not a sign of queueing
+	serve(t, l, now, "POST /auth/login", http.StatusOK, 80*time.Millisecond)
+	serve(t, l, now, "POST /auth/login", http.StatusOK, 90*time.Millisecond)
+	if l.limit != 25 {
+		t.Errorf("limit = %v, want 25", l.limit)
+	}
+}
+
+func TestBaselineIsForgotten(t *testing.T) {
+	l, now := newTestLimiter(t)
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, time.Millisecond)
+	*now = now.Add(l.cfg.BaselineWindow.Duration + time.Second)
+	// The first request of a new window sets the baseline
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 5*time.Millisecond)
+	serve(t, l, now, "GET /users/{id}", http.StatusOK, 6*time.Millisecond)
+	if l.limit != 25 {
+		t.Errorf("limit = %v, want 25", l.limit)
+	}
+}
+
+func TestMiddlewareShedsOverLimit(t *testing.T) {
+	cfg := config.Defaults().Concurrency
+	cfg.InitialLimit = 1
+	cfg.RetryAfter = config.Duration{Duration: 1500 * time.Millisecond}
+	l := New(cfg, "/healthz", "/swagger/")
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// The only slot is taken
+	l.acquire()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want %q", got, "2")
+	}
+	if got := rec.Header().Get("Content-Type"); got != problem.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, problem.ContentType)
+	}
+	if got := l.Stats().Shed; got != 1 {
+		t.Errorf("shed = %d, want 1", got)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/swagger/", http.StatusOK},
+		{"/swagger/index.html", http.StatusOK},
+		{"/healthzX", http.StatusServiceUnavailable},
+		{"/healthz/x", http.StatusServiceUnavailable},
+		{"/swagger", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestGinMiddlewareShedsOverLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.Defaults().Concurrency
+	cfg.InitialLimit = 1
+	l := New(cfg, "/healthz")
+	router := gin.New()
+	router.Use(l.GinMiddleware())
+	router.GET("/healthz", func(c *gin.Context) {})
+	router.GET("/users/:id", func(c *gin.Context) {})
+	// The only slot is taken
+	l.acquire()
+
+	for path, want := range map[string]int{"/users/1": http.StatusServiceUnavailable, "/healthz": http.StatusOK} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
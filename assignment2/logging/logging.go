@@ -0,0 +1,116 @@
+// Package logging sets up the log/slog logger of the servers, and the
+// middleware that gives every request an ID and logs one access line for it.
+//
+// The request ID and a logger carrying it travel in the request context, so
+// anything the request calls, down to the repositories, can log with
+// FromContext(ctx).
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"assignment2/apperr"
+	"assignment2/config"
+)
+
+// New returns a logger writing to w in the format of cfg, "json" or "text",
+// and the level it logs at, which can be changed while the program runs
+func New(cfg config.LogConfig, w io.Writer) (*slog.Logger, *slog.LevelVar) {
+	level := new(slog.LevelVar)
+	if l, err := ParseLevel(cfg.Level); err == nil {
+		level.Set(l)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Format == "text" {
+		return slog.New(slog.NewTextHandler(w, opts)), level
+	}
+	return slog.New(slog.NewJSONHandler(w, opts)), level
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, apperr.Validation("The request body is invalid",
+		apperr.FieldError{Field: "level", Message: "must be one of debug, info, warn, error"})
+}
+
+// LevelSetting is the body of a request to read or change the log level
+type LevelSetting struct {
+	Level string `json:"level" validate:"required"`
+}
+
+// CurrentLevel describes level as a LevelSetting
+func CurrentLevel(level *slog.LevelVar) LevelSetting {
+	return LevelSetting{Level: strings.ToLower(level.Level().String())}
+}
+
+// Fatal logs err with msg and exits
+func Fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+type contextKey struct{}
+
+type requestInfo struct {
+	id     string
+	logger *slog.Logger
+}
+
+// NewContext returns ctx carrying the request ID and a logger that adds it to
+// every line
+func NewContext(ctx context.Context, logger *slog.Logger, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestInfo{
+		id:     requestID,
+		logger: logger.With("request_id", requestID),
+	})
+}
+
+// RequestID returns the ID of the request ctx belongs to, if any
+func RequestID(ctx context.Context) string {
+	info, _ := ctx.Value(contextKey{}).(requestInfo)
+	return info.id
+}
+
+// FromContext returns the logger of the request ctx belongs to, or the
+// default logger outside of requests
+func FromContext(ctx context.Context) *slog.Logger {
+	if info, ok := ctx.Value(contextKey{}).(requestInfo); ok {
+		return info.logger
+	}
+	return slog.Default()
+}
+
+// Checks that a client-supplied request ID is safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HeaderRequestID carries the request ID in requests and responses
+const HeaderRequestID = "X-Request-ID"
+
+// Middleware gives each request an ID, taken from a valid X-Request-ID
+// header or generated, which is echoed in the response and stored in the
+// request context. Once next has served the request, it logs the method,
+// route, status, latency and response size. Behind the tracing middleware,
+// log lines also carry the trace and span IDs. Wrap the ServeMux with Route
+// when middleware in between copies the request.
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r = begin(logger, w, r)
+		route := new(string)
+		r = r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		// The ServeMux records the matched pattern in the request it got,
+		// which is r unless it was copied on the way
+		if *route == "" {
+			*route = r.Pattern
+		}
+		access(r, *route, rec.status, rec.bytes, start)
+	})
+}
+
+type routeKey struct{}
+
+// Route records the pattern that mux matched, like "GET /sql/users/{id}",
+// for the access log. Other middleware may copy the request, so the pattern
+// is only known next to the mux.
+func Route(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*route = r.Pattern
+		}
+	})
+}
+
+// GinMiddleware is Middleware for Gin; use it before the other middleware
+// except tracing
+func GinMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Request = begin(logger, c.Writer, c.Request)
+		c.Next()
+		route := c.FullPath()
+		if route != "" {
+			route = c.Request.Method + " " + route
+		}
+		access(c.Request, route, c.Writer.Status(), int64(c.Writer.Size()), start)
+	}
+}
+
+// Assigns the request ID and returns r with it in the context
+func begin(logger *slog.Logger, w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(HeaderRequestID)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(HeaderRequestID, id)
+	if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+		logger = logger.With("trace_id", span.TraceID().String(), "span_id", span.SpanID().String())
+	}
+	return r.WithContext(NewContext(r.Context(), logger, id))
+}
+
+// Writes the access log line; server errors are logged as errors
+func access(r *http.Request, route string, status int, bytes int64, start time.Time) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if bytes < 0 {
+		bytes = 0
+	}
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	FromContext(r.Context()).LogAttrs(r.Context(), level, "request",
+		slog.String("method", r.Method),
+		slog.String("route", route),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.Duration("latency", time.Since(start)),
+		slog.Int64("bytes", bytes),
+	)
+}
+
+// responseRecorder records the status and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
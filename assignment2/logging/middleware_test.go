@@ -0,0 +1,81 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"assignment2/config"
+	"assignment2/logging"
+	"assignment2/servertiming"
+)
+
+// Serves one request through handler and returns the route of its access line
+func accessRoute(t *testing.T, handler func(logger *slog.Logger) http.Handler, path string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	handler(logger).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry struct {
+			Msg   string  `json:"msg"`
+			Route *string `json:"route"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if entry.Msg == "request" && entry.Route != nil {
+			return *entry.Route
+		}
+	}
+	t.Fatalf("no access line in %q", buf.String())
+	return ""
+}
+
+func TestMiddlewareLogsRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sql/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	timing := servertiming.New(config.ServerTimingConfig{}, nil)
+
+	tests := []struct {
+		name    string
+		handler func(logger *slog.Logger) http.Handler
+		path    string
+		want    string
+	}{
+		{
+			name:    "mux",
+			handler: func(logger *slog.Logger) http.Handler { return logging.Middleware(logger, mux) },
+			path:    "/sql/users/1",
+			want:    "GET /sql/users/{id}",
+		},
+		{
+			// Server timing copies the request before the mux sees it
+			name: "behind server timing",
+			handler: func(logger *slog.Logger) http.Handler {
+				return logging.Middleware(logger, timing.Handler(logging.Route(mux)))
+			},
+			path: "/sql/users/1",
+			want: "GET /sql/users/{id}",
+		},
+		{
+			name: "unmatched",
+			handler: func(logger *slog.Logger) http.Handler {
+				return logging.Middleware(logger, timing.Handler(logging.Route(mux)))
+			},
+			path: "/sql/nothing",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := accessRoute(t, tt.handler, tt.path); got != tt.want {
+				t.Errorf("route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Package metrics exports Prometheus metrics for the HTTP servers: request
+// counts and latencies per route, the latency of each repository operation,
+// the statistics of the connection pools and the concurrency limiter, and
+// the Go runtime metrics.
+//
+// Routes are labelled with their templates, such as /gorm/user/:id or
+// /sql/users/{id}, never with raw paths, so that the number of series stays
+// bounded. Requests that were not routed, because no route matched or they
+// were rejected before routing, are counted under the route "unmatched".
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"assignment2/loadshed"
+)
+
+// Unmatched is the route label of requests that no route served
+const Unmatched = "unmatched"
+
+// Metrics holds the collectors of one server and the registry serving them
+type Metrics struct {
+	registry   *prometheus.Registry
+	requests   *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	operations *prometheus.HistogramVec
+}
+
+// New returns metrics registered with a new registry, together with the Go
+// runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time to serve HTTP requests, by method and route template.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		// Queries are mostly much faster than requests
+		operations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "repository_operation_duration_seconds",
+			Help:    "Time taken by repository operations, by backend, operation and outcome.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"backend", "operation", "outcome"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.latency, m.operations,
+	)
+	return m
+}
+
+// RegisterLimiter exports the limit, the requests in flight and the requests
+// shed so far of the adaptive concurrency limiter l
+func (m *Metrics) RegisterLimiter(l *loadshed.Limiter) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "concurrency_limit",
+			Help: "Requests the adaptive concurrency limiter currently serves at once.",
+		}, func() float64 { return float64(l.Stats().Limit) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "concurrency_in_flight",
+			Help: "Requests being served under the concurrency limit.",
+		}, func() float64 { return float64(l.Stats().InFlight) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "concurrency_shed_requests_total",
+			Help: "Requests rejected by the concurrency limiter.",
+		}, func() float64 { return float64(l.Stats().Shed) }),
+	)
+}
+
+// RegisterDB exports the sql.DBStats of db, such as open, in-use and idle
+// connections and the time spent waiting for one, labelled with name
+func (m *Metrics) RegisterDB(name string, db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
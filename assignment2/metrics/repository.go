@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"assignment2/apperr"
+	"assignment2/models"
+	"assignment2/querybuilder"
+	"assignment2/repository"
+)
+
+// Users wraps repo so that the latency of each operation is recorded under
+// backend, such as "sql" or "gorm"
+func (m *Metrics) Users(repo repository.UserRepository, backend string) repository.UserRepository {
+	return &users{repo: repo, timer: timer{m: m, backend: backend}}
+}
+
+// Tokens is Users for a TokenRepository
+func (m *Metrics) Tokens(repo repository.TokenRepository, backend string) repository.TokenRepository {
+	return &tokens{repo: repo, timer: timer{m: m, backend: backend}}
+}
+
+type timer struct {
+	m       *Metrics
+	backend string
+}
+
+// done records an operation started at start. The outcome is "ok" or the
+// error code, so that not-found lookups can be told from failures.
+func (t timer) done(operation string, start time.Time, err *error) {
+	outcome := "ok"
+	if *err != nil {
+		outcome = string(apperr.From(*err).Code)
+	}
+	t.m.operations.WithLabelValues(t.backend, operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+type users struct {
+	repo repository.UserRepository
+	timer
+}
+
+func (r *users) Create(ctx context.Context, user *models.User) (err error) {
+	defer r.done("Create", time.Now(), &err)
+	return r.repo.Create(ctx, user)
+}
+
+func (r *users) Get(ctx context.Context, id uint) (_ models.User, err error) {
+	defer r.done("Get", time.Now(), &err)
+	return r.repo.Get(ctx, id)
+}
+
+func (r *users) GetByName(ctx context.Context, name string) (_ models.User, err error) {
+	defer r.done("GetByName", time.Now(), &err)
+	return r.repo.GetByName(ctx, name)
+}
+
+func (r *users) List(ctx context.Context, params querybuilder.ListParams, limit, offset int) (_ []models.User, err error) {
+	defer r.done("List", time.Now(), &err)
+	return r.repo.List(ctx, params, limit, offset)
+}
+
+func (r *users) Count(ctx context.Context, params querybuilder.ListParams) (_ int64, err error) {
+	defer r.done("Count", time.Now(), &err)
+	return r.repo.Count(ctx, params)
+}
+
+func (r *users) Update(ctx context.Context, id uint, update repository.UserUpdate) (_ models.User, err error) {
+	defer r.done("Update", time.Now(), &err)
+	return r.repo.Update(ctx, id, update)
+}
+
+func (r *users) Delete(ctx context.Context, id uint) (err error) {
+	defer r.done("Delete", time.Now(), &err)
+	return r.repo.Delete(ctx, id)
+}
+
+func (r *users) SetRole(ctx context.Context, id uint, role string) (_ models.User, err error) {
+	defer r.done("SetRole", time.Now(), &err)
+	return r.repo.SetRole(ctx, id, role)
+}
+
+func (r *users) GetProfile(ctx context.Context, userID uint) (_ models.Profile, err error) {
+	defer r.done("GetProfile", time.Now(), &err)
+	return r.repo.GetProfile(ctx, userID)
+}
+
+func (r *users) SaveProfile(ctx context.Context, userID uint, profile models.Profile) (_ models.Profile, err error) {
+	defer r.done("SaveProfile", time.Now(), &err)
+	return r.repo.SaveProfile(ctx, userID, profile)
+}
+
+type tokens struct {
+	repo repository.TokenRepository
+	timer
+}
+
+func (r *tokens) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) (err error) {
+	defer r.done("CreateRefreshToken", time.Now(), &err)
+	return r.repo.CreateRefreshToken(ctx, token)
+}
+
+func (r *tokens) GetRefreshToken(ctx context.Context, hash string) (_ models.RefreshToken, err error) {
+	defer r.done("GetRefreshToken", time.Now(), &err)
+	return r.repo.GetRefreshToken(ctx, hash)
+}
+
+func (r *tokens) RevokeRefreshToken(ctx context.Context, id uint, at time.Time) (_ bool, err error) {
+	defer r.done("RevokeRefreshToken", time.Now(), &err)
+	return r.repo.RevokeRefreshToken(ctx, id, at)
+}
+
+func (r *tokens) RevokeUserRefreshTokens(ctx context.Context, userID uint, at time.Time) (err error) {
+	defer r.done("RevokeUserRefreshTokens", time.Now(), &err)
+	return r.repo.RevokeUserRefreshTokens(ctx, userID, at)
+}
+
+func (r *tokens) RevokeAccessToken(ctx context.Context, token models.RevokedToken) (err error) {
+	defer r.done("RevokeAccessToken", time.Now(), &err)
+	return r.repo.RevokeAccessToken(ctx, token)
+}
+
+func (r *tokens) IsAccessTokenRevoked(ctx context.Context, id string) (_ bool, err error) {
+	defer r.done("IsAccessTokenRevoked", time.Now(), &err)
+	return r.repo.IsAccessTokenRevoked(ctx, id)
+}
+
+func (r *tokens) CreateAPIKey(ctx context.Context, key *models.APIKey) (err error) {
+	defer r.done("CreateAPIKey", time.Now(), &err)
+	return r.repo.CreateAPIKey(ctx, key)
+}
+
+func (r *tokens) GetAPIKey(ctx context.Context, hash string) (_ models.APIKey, err error) {
+	defer r.done("GetAPIKey", time.Now(), &err)
+	return r.repo.GetAPIKey(ctx, hash)
+}
+
+func (r *tokens) ListAPIKeys(ctx context.Context, userID uint) (_ []models.APIKey, err error) {
+	defer r.done("ListAPIKeys", time.Now(), &err)
+	return r.repo.ListAPIKeys(ctx, userID)
+}
+
+func (r *tokens) RevokeAPIKey(ctx context.Context, userID, id uint, at time.Time) (err error) {
+	defer r.done("RevokeAPIKey", time.Now(), &err)
+	return r.repo.RevokeAPIKey(ctx, userID, id, at)
+}
+
+func (r *tokens) TouchAPIKey(ctx context.Context, id uint, at time.Time) (err error) {
+	defer r.done("TouchAPIKey", time.Now(), &err)
+	return r.repo.TouchAPIKey(ctx, id, at)
+}
+
+func (r *tokens) GetTwoFactor(ctx context.Context, userID uint) (_ models.TwoFactor, err error) {
+	defer r.done("GetTwoFactor", time.Now(), &err)
+	return r.repo.GetTwoFactor(ctx, userID)
+}
+
+func (r *tokens) SaveTwoFactor(ctx context.Context, tf *models.TwoFactor) (err error) {
+	defer r.done("SaveTwoFactor", time.Now(), &err)
+	return r.repo.SaveTwoFactor(ctx, tf)
+}
+
+func (r *tokens) ConfirmTwoFactor(ctx context.Context, id uint, step int64, at time.Time, codes []models.RecoveryCode) (err error) {
+	defer r.done("ConfirmTwoFactor", time.Now(), &err)
+	return r.repo.ConfirmTwoFactor(ctx, id, step, at, codes)
+}
+
+func (r *tokens) UseTOTPStep(ctx context.Context, id uint, step int64) (_ bool, err error) {
+	defer r.done("UseTOTPStep", time.Now(), &err)
+	return r.repo.UseTOTPStep(ctx, id, step)
+}
+
+func (r *tokens) UseRecoveryCode(ctx context.Context, twoFactorID uint, hash string, at time.Time) (_ bool, err error) {
+	defer r.done("UseRecoveryCode", time.Now(), &err)
+	return r.repo.UseRecoveryCode(ctx, twoFactorID, hash, at)
+}
+
+func (r *tokens) DeleteTwoFactor(ctx context.Context, userID uint) (err error) {
+	defer r.done("DeleteTwoFactor", time.Now(), &err)
+	return r.repo.DeleteTwoFactor(ctx, userID)
+}
@@ -0,0 +1,53 @@
+// Package models holds the database models shared by the servers and
+// repositories. The validate tags are enforced by package validation, and
+// the pii tags mark personal data that package redact keeps out of logs.
+package models
+
+import (
+	"log/slog"
+
+	"assignment2/redact"
+)
+
+// Roles a user can have; see package policy for what each may do
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// User model
+type User struct {
+	ID      uint    `json:"id" gorm:"primaryKey"`
+	Name    string  `json:"name" gorm:"size:100;unique;not null" validate:"required,max=100" pii:"hash"`
+	Age     int     `json:"age" gorm:"not null" validate:"min=0,max=150"`
+	Profile Profile `json:"profile" gorm:"foreignKey:UserID"`
+	// Role is assigned through the admin endpoint; it is ignored on create and update
+	Role string `json:"role" gorm:"size:20;not null;default:'user'"`
+	// PasswordHash is the bcrypt hash of the password; users without one cannot log in
+	PasswordHash string `json:"-" gorm:"size:255;not null;default:''"`
+	// TwoFactor is only loaded by package auth
+	TwoFactor *TwoFactor `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// Profile model (one-to-one relationship with User)
+type Profile struct {
+	ID                uint   `json:"id" gorm:"primaryKey"`
+	UserID            uint   `json:"user_id" gorm:"unique;not null"`
+	Bio               string `json:"bio" validate:"max=500" pii:"mask"`
+	ProfilePictureURL string `json:"profile_picture_url" validate:"max=2048,url=http|https" pii:"mask"`
+}
+
+// LogValue logs the user with its personal data redacted
+func (u User) LogValue() slog.Value {
+	return redact.Value(u)
+}
+
+// LogValue logs the profile with its personal data redacted
+func (p Profile) LogValue() slog.Value {
+	return redact.Value(p)
+}
+
+// IsZero reports whether the profile carries no data, so it need not be stored
+func (p Profile) IsZero() bool {
+	return p.Bio == "" && p.ProfilePictureURL == ""
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RefreshToken is a long-lived token that can be exchanged once for a new
+// access and refresh token. Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	Hash      string     `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	RevokedAt *time.Time // set when the token is used, or on logout
+}
+
+// RevokedToken records an access token revoked on logout before it expired
+type RevokedToken struct {
+	ID        string    `gorm:"primaryKey;size:36"` // the token's jti claim
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// TwoFactor is the TOTP (RFC 6238) enrollment of a user. It is created
+// unconfirmed and only enforced at login once the user has confirmed it
+// with a valid code.
+type TwoFactor struct {
+	ID          uint       `gorm:"primaryKey"`
+	UserID      uint       `gorm:"unique;not null"`
+	Secret      string     `gorm:"size:64;not null"` // base32, as shown to authenticator apps
+	ConfirmedAt *time.Time // nil until the first valid code
+	// LastUsedStep is the time step of the last accepted code, so that a code
+	// cannot be used twice
+	LastUsedStep  int64          `gorm:"not null;default:0"`
+	RecoveryCodes []RecoveryCode `gorm:"foreignKey:TwoFactorID;constraint:OnDelete:CASCADE"`
+}
+
+// Enabled reports whether logins require a second factor
+func (t TwoFactor) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the
+// authenticator is lost. Only the SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID          uint   `gorm:"primaryKey"`
+	TwoFactorID uint   `gorm:"index;not null"`
+	Hash        string `gorm:"size:64;not null"`
+	UsedAt      *time.Time
+}
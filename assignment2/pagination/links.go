@@ -0,0 +1,95 @@
+package pagination
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Envelope is the optional wrapped list response, requested with ?envelope=true
+type Envelope[T any] struct {
+	Data       []T    `json:"data"`
+	Page       int    `json:"page,omitempty"` // omitted for cursor requests
+	PerPage    int    `json:"per_page"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// WantsEnvelope reports whether the client asked for the wrapped response
+func WantsEnvelope(q url.Values) bool {
+	v, _ := strconv.ParseBool(q.Get("envelope"))
+	return v
+}
+
+// Envelope wraps the page. Data is never null so clients can always iterate it.
+func (res Result[T]) Envelope(req Request, total int64) Envelope[T] {
+	env := Envelope[T]{
+		Data:       res.Items,
+		PerPage:    req.Limit,
+		Total:      total,
+		NextCursor: res.Next,
+		PrevCursor: res.Prev,
+	}
+	if env.Data == nil {
+		env.Data = []T{}
+	}
+	if req.Cursor == nil {
+		env.Page = req.Page
+	}
+	return env
+}
+
+// LastPage returns the number of the last page, which is 1 for an empty list
+func LastPage(total int64, limit int) int {
+	if total <= 0 || limit <= 0 {
+		return 1
+	}
+	return int((total + int64(limit) - 1) / int64(limit))
+}
+
+// Returns u with the pagination parameters replaced by set
+func pageURL(u *url.URL, set url.Values) string {
+	q := u.Query()
+	q.Del("cursor")
+	q.Del("page")
+	for k, v := range set {
+		q[k] = v
+	}
+	return (&url.URL{Path: u.Path, RawQuery: q.Encode()}).String()
+}
+
+// SetHeaders writes the RFC 8288 Link header (first, prev, next, last),
+// X-Total-Count and the X-Next-Cursor/X-Prev-Cursor headers for the page
+// served from u. Cursor links are used where available, page links otherwise.
+func SetHeaders[T any](h http.Header, u *url.URL, req Request, res Result[T], total int64) {
+	limit := strconv.Itoa(req.Limit)
+	last := LastPage(total, req.Limit)
+	links := []string{link(pageURL(u, url.Values{"page": {"1"}, "limit": {limit}}), "first")}
+
+	switch {
+	case res.Prev != "":
+		links = append(links, link(pageURL(u, url.Values{"cursor": {res.Prev}, "limit": {limit}}), "prev"))
+	case req.Cursor == nil && req.Page > 1:
+		links = append(links, link(pageURL(u, url.Values{"page": {strconv.Itoa(min(req.Page-1, last))}, "limit": {limit}}), "prev"))
+	}
+	if res.Next != "" {
+		links = append(links, link(pageURL(u, url.Values{"cursor": {res.Next}, "limit": {limit}}), "next"))
+	}
+	links = append(links, link(pageURL(u, url.Values{"page": {strconv.Itoa(last)}, "limit": {limit}}), "last"))
+
+	h.Set("Link", strings.Join(links, ", "))
+	h.Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if res.Next != "" {
+		h.Set("X-Next-Cursor", res.Next)
+	}
+	if res.Prev != "" {
+		h.Set("X-Prev-Cursor", res.Prev)
+	}
+}
+
+func link(target, rel string) string {
+	return fmt.Sprintf("<%s>; rel=%q", target, rel)
+}
@@ -0,0 +1,141 @@
+package pagination
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var linkPattern = regexp.MustCompile(`<([^>]*)>; rel="([a-z]+)"`)
+
+// Returns the Link header's targets by relation, failing on repeated ones or
+// relations out of the first, prev, next, last order
+func parseLinks(t *testing.T, header string) map[string]string {
+	t.Helper()
+	order := map[string]int{"first": 1, "prev": 2, "next": 3, "last": 4}
+	links := map[string]string{}
+	last := 0
+	for _, m := range linkPattern.FindAllStringSubmatch(header, -1) {
+		if _, ok := links[m[2]]; ok {
+			t.Fatalf("rel %q appears twice in %q", m[2], header)
+		}
+		if order[m[2]] <= last {
+			t.Fatalf("rel %q out of order in %q", m[2], header)
+		}
+		last = order[m[2]]
+		links[m[2]] = m[1]
+	}
+	return links
+}
+
+func TestSetHeadersPages(t *testing.T) {
+	c := NewCodec([]byte("secret"))
+	key := func(id int) []interface{} { return []interface{}{int64(id)} }
+	tests := []struct {
+		name      string
+		query     string
+		rows      []int
+		total     int64
+		wantLinks map[string]string
+	}{
+		{
+			name:  "first page",
+			query: "limit=2&age_min=18",
+			rows:  []int{1, 2, 3},
+			total: 5,
+			wantLinks: map[string]string{
+				"first": "/users?age_min=18&limit=2&page=1",
+				"next":  "cursor",
+				"last":  "/users?age_min=18&limit=2&page=3",
+			},
+		},
+		{
+			name:  "middle page",
+			query: "limit=2&page=2&age_min=18",
+			rows:  []int{3, 4, 5},
+			total: 5,
+			wantLinks: map[string]string{
+				"first": "/users?age_min=18&limit=2&page=1",
+				"prev":  "cursor",
+				"next":  "cursor",
+				"last":  "/users?age_min=18&limit=2&page=3",
+			},
+		},
+		{
+			// Nothing to take a cursor from, so prev goes to the last page
+			name:  "past the end",
+			query: "limit=2&page=9",
+			rows:  nil,
+			total: 5,
+			wantLinks: map[string]string{
+				"first": "/users?limit=2&page=1",
+				"prev":  "/users?limit=2&page=3",
+				"last":  "/users?limit=2&page=3",
+			},
+		},
+		{
+			name:  "empty list",
+			query: "",
+			rows:  nil,
+			total: 0,
+			wantLinks: map[string]string{
+				"first": "/users?limit=10&page=1",
+				"last":  "/users?limit=10&page=1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/users?"+tt.query, nil)
+			req, err := c.Parse(r.URL.Query(), "id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := Paginate(c, req, tt.rows, key)
+			rec := httptest.NewRecorder()
+			SetHeaders(rec.Header(), r.URL, req, res, tt.total)
+
+			links := parseLinks(t, rec.Header().Get("Link"))
+			if len(links) != len(tt.wantLinks) {
+				t.Errorf("Link = %q, want relations %v", rec.Header().Get("Link"), tt.wantLinks)
+			}
+			for rel, want := range tt.wantLinks {
+				got, ok := links[rel]
+				if !ok {
+					t.Errorf("no %q link", rel)
+					continue
+				}
+				if want != "cursor" {
+					if got != want {
+						t.Errorf("%s = %q, want %q", rel, got, want)
+					}
+					continue
+				}
+				// Cursor links keep the filters and the limit, without a page
+				u, err := url.Parse(got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				q := u.Query()
+				if q.Has("page") || q.Get("limit") != r.URL.Query().Get("limit") || q.Get("age_min") != "18" {
+					t.Errorf("%s = %q", rel, got)
+				}
+				if _, err := c.Resume(q.Get("cursor"), req.Limit, "id"); err != nil {
+					t.Errorf("%s cursor: %v", rel, err)
+				}
+			}
+
+			if got, want := rec.Header().Get("X-Total-Count"), strconv.FormatInt(tt.total, 10); got != want {
+				t.Errorf("X-Total-Count = %q, want %q", got, want)
+			}
+			if got := rec.Header().Get("X-Next-Cursor"); got != res.Next {
+				t.Errorf("X-Next-Cursor = %q, want %q", got, res.Next)
+			}
+			if got := rec.Header().Get("X-Prev-Cursor"); got != res.Prev {
+				t.Errorf("X-Prev-Cursor = %q, want %q", got, res.Prev)
+			}
+		})
+	}
+}
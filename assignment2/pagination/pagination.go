@@ -0,0 +1,203 @@
+// Package pagination implements keyset (cursor) pagination with opaque,
+// HMAC-signed cursor tokens, while still accepting the older page numbers.
+package pagination
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultLimit = 10  // page size when the client does not send limit
+	MaxLimit     = 100 // largest page size a client may request
+)
+
+// ErrInvalidCursor is returned for cursors that are malformed, were signed
+// with another secret, or were issued for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the decoded content of a cursor token
+type Cursor struct {
+	Values   []interface{} `json:"v"`           // sort column values of the boundary row
+	Backward bool          `json:"b,omitempty"` // true for a "prev" cursor
+	Sort     string        `json:"s"`           // sort order the cursor was issued for
+}
+
+// Codec signs and verifies cursor tokens
+type Codec struct {
+	secret []byte
+}
+
+// NewCodec returns a codec signing with secret. An empty secret is replaced
+// by a random one, so tokens only stay valid until the process restarts.
+func NewCodec(secret []byte) *Codec {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	}
+	return &Codec{secret: secret}
+}
+
+func (c *Codec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Encode returns the opaque token for cur
+func (c *Codec) Encode(cur Cursor) string {
+	payload, _ := json.Marshal(cur)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(c.sign(payload))
+}
+
+// Decode verifies token and returns its cursor
+func (c *Codec) Decode(token string) (Cursor, error) {
+	var cur Cursor
+	enc := base64.RawURLEncoding
+	data, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return cur, ErrInvalidCursor
+	}
+	payload, err := enc.DecodeString(data)
+	if err != nil {
+		return cur, ErrInvalidCursor
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, c.sign(payload)) {
+		return cur, ErrInvalidCursor
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&cur); err != nil {
+		return cur, ErrInvalidCursor
+	}
+	// Keep integers as integers rather than float64 so large IDs compare exactly
+	for i, v := range cur.Values {
+		if n, ok := v.(json.Number); ok {
+			if iv, err := n.Int64(); err == nil {
+				cur.Values[i] = iv
+			} else {
+				cur.Values[i] = n.String()
+			}
+		}
+	}
+	return cur, nil
+}
+
+// Request is a parsed pagination request. Cursor takes precedence over Page.
+type Request struct {
+	Limit  int
+	Page   int
+	Sort   string
+	Cursor *Cursor
+}
+
+// Offset returns the row offset for page based requests
+func (r Request) Offset() int {
+	if r.Cursor != nil || r.Page < 1 {
+		return 0
+	}
+	return (r.Page - 1) * r.Limit
+}
+
+// Resume builds a request continuing from token (which may be empty for the
+// first page), rejecting tokens issued for a sort order other than sort.
+func (c *Codec) Resume(token string, limit int, sort string) (Request, error) {
+	req := Request{Limit: limit, Page: 1, Sort: sort}
+	if req.Limit <= 0 {
+		req.Limit = DefaultLimit
+	}
+	if req.Limit > MaxLimit {
+		req.Limit = MaxLimit
+	}
+	if token == "" {
+		return req, nil
+	}
+	cur, err := c.Decode(token)
+	if err != nil {
+		return req, err
+	}
+	if cur.Sort != sort {
+		return req, ErrInvalidCursor
+	}
+	req.Cursor = &cur
+	return req, nil
+}
+
+// Parse reads cursor, limit and page from the query string
+func (c *Codec) Parse(q url.Values, sort string) (Request, error) {
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Request{}, fmt.Errorf("invalid limit %q", v)
+		}
+		limit = n
+	}
+
+	req, err := c.Resume(q.Get("cursor"), limit, sort)
+	if err != nil {
+		return req, err
+	}
+	if v := q.Get("page"); v != "" && req.Cursor == nil {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return req, fmt.Errorf("invalid page number %q", v)
+		}
+		req.Page = page
+	}
+	return req, nil
+}
+
+// Result is one page of items together with the cursors around it
+type Result[T any] struct {
+	Items []T
+	Next  string // empty when there is no next page
+	Prev  string // empty when there is no previous page
+}
+
+// Paginate turns the rows fetched for req (up to Limit+1, in query order)
+// into a page. key returns the sort column values of an item.
+func Paginate[T any](c *Codec, req Request, rows []T, key func(T) []interface{}) Result[T] {
+	more := len(rows) > req.Limit
+	if more {
+		rows = rows[:req.Limit]
+	}
+
+	backward := req.Cursor != nil && req.Cursor.Backward
+	var hasNext, hasPrev bool
+	if backward {
+		// Rows were fetched in reverse order; restore the requested order
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+		hasNext, hasPrev = true, more
+	} else {
+		hasNext, hasPrev = more, req.Cursor != nil || req.Page > 1
+	}
+
+	res := Result[T]{Items: rows}
+	if len(rows) == 0 {
+		return res
+	}
+	if hasNext {
+		res.Next = c.Encode(Cursor{Values: key(rows[len(rows)-1]), Sort: req.Sort})
+	}
+	if hasPrev {
+		res.Prev = c.Encode(Cursor{Values: key(rows[0]), Backward: true, Sort: req.Sort})
+	}
+	return res
+}
@@ -0,0 +1,154 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	c := NewCodec([]byte("secret"))
+	cur := Cursor{Values: []interface{}{"bob", int64(9007199254740993)}, Backward: true, Sort: "name,id"}
+	got, err := c.Decode(c.Encode(cur))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Large IDs come back as exact integers, not float64
+	if !reflect.DeepEqual(got, cur) {
+		t.Errorf("Decode = %+v, want %+v", got, cur)
+	}
+}
+
+func TestCodecRejectsTamperedCursors(t *testing.T) {
+	c := NewCodec([]byte("secret"))
+	token := c.Encode(Cursor{Values: []interface{}{int64(10)}, Sort: "id"})
+	data, sig, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"v":[1000],"s":"id"}`))
+
+	tests := map[string]string{
+		"empty":              "",
+		"no signature":       data,
+		"forged payload":     forged + "." + sig,
+		"truncated sig":      data + "." + sig[:len(sig)-2],
+		"not base64":         "!!!." + sig,
+		"other secret":       NewCodec([]byte("other")).Encode(Cursor{Values: []interface{}{int64(10)}, Sort: "id"}),
+		"signed non-JSON":    base64.RawURLEncoding.EncodeToString([]byte("x")) + "." + base64.RawURLEncoding.EncodeToString(c.sign([]byte("x"))),
+		"random secret":      NewCodec(nil).Encode(Cursor{Sort: "id"}),
+		"signature of other": data + "." + strings.Split(c.Encode(Cursor{Sort: "id"}), ".")[1],
+	}
+	for name, token := range tests {
+		if _, err := c.Decode(token); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: Decode err = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestResumeRejectsOtherSortOrder(t *testing.T) {
+	c := NewCodec([]byte("secret"))
+	token := c.Encode(Cursor{Values: []interface{}{"bob", int64(3)}, Sort: "name,id"})
+
+	if _, err := c.Resume(token, 10, "-name,id"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Resume under another sort order: err = %v, want ErrInvalidCursor", err)
+	}
+	req, err := c.Resume(token, 10, "name,id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Cursor == nil || !reflect.DeepEqual(req.Cursor.Values, []interface{}{"bob", int64(3)}) {
+		t.Errorf("Resume cursor = %+v", req.Cursor)
+	}
+}
+
+func TestLimitBounds(t *testing.T) {
+	c := NewCodec([]byte("secret"))
+	for limit, want := range map[int]int{-1: DefaultLimit, 0: DefaultLimit, 1: 1, MaxLimit: MaxLimit, MaxLimit + 1: MaxLimit} {
+		req, err := c.Resume("", limit, "id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Limit != want {
+			t.Errorf("Resume limit %d: Limit = %d, want %d", limit, req.Limit, want)
+		}
+	}
+
+	for _, query := range []string{"limit=0", "limit=-5", "limit=ten", "page=0", "page=x"} {
+		q, _ := url.ParseQuery(query)
+		if _, err := c.Parse(q, "id"); err == nil {
+			t.Errorf("Parse(%q) succeeded", query)
+		}
+	}
+	q, _ := url.ParseQuery("limit=500&page=3")
+	req, err := c.Parse(q, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Limit != MaxLimit || req.Page != 3 || req.Offset() != 2*MaxLimit {
+		t.Errorf("Parse = %+v, offset %d", req, req.Offset())
+	}
+}
+
+func TestPaginateForward(t *testing.T) {
+	c := NewCodec([]byte("secret"))
+	key := func(id int) []interface{} { return []interface{}{int64(id)} }
+
+	first, _ := c.Resume("", 2, "id")
+	res := Paginate(c, first, []int{1, 2, 3}, key)
+	if !reflect.DeepEqual(res.Items, []int{1, 2}) || res.Prev != "" || res.Next == "" {
+		t.Fatalf("first page = %+v", res)
+	}
+
+	next, err := c.Resume(res.Next, 2, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(next.Cursor.Values, []interface{}{int64(2)}) || next.Cursor.Backward {
+		t.Errorf("next cursor = %+v", next.Cursor)
+	}
+	// The last page has no next cursor but can go back
+	res = Paginate(c, next, []int{3}, key)
+	if !reflect.DeepEqual(res.Items, []int{3}) || res.Next != "" || res.Prev == "" {
+		t.Errorf("last page = %+v", res)
+	}
+}
+
+func TestPaginateBackward(t *testing.T) {
+	c := NewCodec([]byte("secret"))
+	key := func(id int) []interface{} { return []interface{}{int64(id)} }
+	token := c.Encode(Cursor{Values: []interface{}{int64(5)}, Backward: true, Sort: "id"})
+	req, err := c.Resume(token, 2, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fetched in reverse order, with one row more than the limit
+	res := Paginate(c, req, []int{4, 3, 2}, key)
+	if !reflect.DeepEqual(res.Items, []int{3, 4}) {
+		t.Fatalf("items = %v, want [3 4]", res.Items)
+	}
+	prev, err := c.Decode(res.Prev)
+	if err != nil || !prev.Backward || !reflect.DeepEqual(prev.Values, []interface{}{int64(3)}) {
+		t.Errorf("prev cursor = %+v, %v", prev, err)
+	}
+	next, err := c.Decode(res.Next)
+	if err != nil || next.Backward || !reflect.DeepEqual(next.Values, []interface{}{int64(4)}) {
+		t.Errorf("next cursor = %+v, %v", next, err)
+	}
+
+	// Reaching the start leaves no previous page
+	res = Paginate(c, req, []int{2, 1}, key)
+	if !reflect.DeepEqual(res.Items, []int{1, 2}) || res.Prev != "" || res.Next == "" {
+		t.Errorf("first page going back = %+v", res)
+	}
+}
+
+func TestPaginateEmpty(t *testing.T) {
+	c := NewCodec([]byte("secret"))
+	req, _ := c.Resume("", 10, "id")
+	res := Paginate(c, req, nil, func(int) []interface{} { return nil })
+	if res.Next != "" || res.Prev != "" || len(res.Items) != 0 {
+		t.Errorf("empty page = %+v", res)
+	}
+}
@@ -0,0 +1,258 @@
+// Package policy decides what an authenticated caller may do with users and
+// profiles. Each role grants actions either on every user or only on the
+// caller's own account; Guard wraps a UserRepository so that every operation
+// is checked against the caller's role before it runs.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"assignment2/apperr"
+	"assignment2/auth"
+	"assignment2/models"
+	"assignment2/querybuilder"
+	"assignment2/repository"
+)
+
+// Action is an operation on users or profiles
+type Action string
+
+const (
+	ReadUser     Action = "user.read"
+	CreateUser   Action = "user.create"
+	UpdateUser   Action = "user.update"
+	DeleteUser   Action = "user.delete"
+	ReadProfile  Action = "profile.read"
+	WriteProfile Action = "profile.write"
+	AssignRole   Action = "role.assign"
+	// ResetTwoFactor removes a user's two-factor enrollment
+	ResetTwoFactor Action = "twofactor.reset"
+	// SetLogLevel reads or changes the log level of the server
+	SetLogLevel Action = "log.level"
+	// ViewServerTiming asks for the Server-Timing header with X-Debug-Timing
+	ViewServerTiming Action = "debug.timing"
+	// ViewSlowQueries reads the per-shape query statistics
+	ViewSlowQueries Action = "debug.slow_queries"
+)
+
+// Grant says on whose rows a role may perform an action
+type Grant int
+
+const (
+	Denied  Grant = iota
+	OwnRows       // only the caller's own user and profile
+	AllRows
+)
+
+// Roles maps each role to the actions it grants
+var Roles = map[string]map[Action]Grant{
+	models.RoleAdmin: {
+		ReadUser:         AllRows,
+		CreateUser:       AllRows,
+		UpdateUser:       AllRows,
+		DeleteUser:       AllRows,
+		ReadProfile:      AllRows,
+		WriteProfile:     AllRows,
+		AssignRole:       AllRows,
+		ResetTwoFactor:   AllRows,
+		SetLogLevel:      AllRows,
+		ViewServerTiming: AllRows,
+		ViewSlowQueries:  AllRows,
+	},
+	models.RoleUser: {
+		ReadUser:     OwnRows,
+		UpdateUser:   OwnRows,
+		ReadProfile:  OwnRows,
+		WriteProfile: OwnRows,
+	},
+}
+
+// Check returns a forbidden error unless role may perform action on the rows
+// of the user ownerID when called by callerID. Use ownerID 0 for actions
+// that do not concern an existing user.
+func Check(role string, action Action, callerID, ownerID uint) error {
+	switch Roles[role][action] {
+	case AllRows:
+		return nil
+	case OwnRows:
+		if ownerID != 0 && ownerID == callerID {
+			return nil
+		}
+		return apperr.Forbidden(fmt.Sprintf("the %s role only permits %s on its own account", role, action))
+	}
+	return apperr.Forbidden(fmt.Sprintf("the %s role does not permit %s", role, action))
+}
+
+// RoleAssignment is the body of a request to change a user's role
+type RoleAssignment struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// Guard is a UserRepository that checks each operation against the role of
+// the caller. It loads the caller's role on first use, so a role change
+// applies to the next request; a Guard serves a single request.
+type Guard struct {
+	repo   repository.UserRepository
+	caller auth.Identity
+	role   string
+}
+
+// NewGuard returns repo as seen by caller
+func NewGuard(repo repository.UserRepository, caller auth.Identity) *Guard {
+	return &Guard{repo: repo, caller: caller}
+}
+
+func (g *Guard) callerRole(ctx context.Context) (string, error) {
+	if g.role != "" {
+		return g.role, nil
+	}
+	user, err := g.repo.Get(ctx, g.caller.UserID)
+	if errors.Is(err, apperr.ErrNotFound) {
+		return "", apperr.Unauthorized("the caller's account no longer exists")
+	}
+	if err != nil {
+		return "", err
+	}
+	g.role = user.Role
+	return g.role, nil
+}
+
+// Authorize checks an action that is not a repository operation, on the
+// rows of user ownerID
+func (g *Guard) Authorize(ctx context.Context, action Action, ownerID uint) error {
+	return g.check(ctx, action, ownerID)
+}
+
+func (g *Guard) check(ctx context.Context, action Action, ownerID uint) error {
+	role, err := g.callerRole(ctx)
+	if err != nil {
+		return err
+	}
+	return Check(role, action, g.caller.UserID, ownerID)
+}
+
+// Narrows params to the caller's own user when it may not list everyone
+func (g *Guard) limit(ctx context.Context, params querybuilder.ListParams) (querybuilder.ListParams, error) {
+	role, err := g.callerRole(ctx)
+	if err != nil {
+		return params, err
+	}
+	switch Roles[role][ReadUser] {
+	case AllRows:
+	case OwnRows:
+		params.ID = &g.caller.UserID
+	default:
+		return params, Check(role, ReadUser, g.caller.UserID, 0)
+	}
+	return params, nil
+}
+
+func (g *Guard) Create(ctx context.Context, user *models.User) error {
+	if err := g.check(ctx, CreateUser, 0); err != nil {
+		return err
+	}
+	// Roles are only changed through SetRole
+	user.Role = ""
+	return g.repo.Create(ctx, user)
+}
+
+func (g *Guard) Get(ctx context.Context, id uint) (models.User, error) {
+	if err := g.check(ctx, ReadUser, id); err != nil {
+		return models.User{}, err
+	}
+	return g.repo.Get(ctx, id)
+}
+
+func (g *Guard) GetByName(ctx context.Context, name string) (models.User, error) {
+	user, err := g.repo.GetByName(ctx, name)
+	if err != nil {
+		return user, err
+	}
+	if err := g.check(ctx, ReadUser, user.ID); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (g *Guard) List(ctx context.Context, params querybuilder.ListParams, limit, offset int) ([]models.User, error) {
+	params, err := g.limit(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return g.repo.List(ctx, params, limit, offset)
+}
+
+func (g *Guard) Count(ctx context.Context, params querybuilder.ListParams) (int64, error) {
+	params, err := g.limit(ctx, params)
+	if err != nil {
+		return 0, err
+	}
+	return g.repo.Count(ctx, params)
+}
+
+func (g *Guard) Update(ctx context.Context, id uint, update repository.UserUpdate) (models.User, error) {
+	if err := g.check(ctx, UpdateUser, id); err != nil {
+		return models.User{}, err
+	}
+	return g.repo.Update(ctx, id, update)
+}
+
+func (g *Guard) Delete(ctx context.Context, id uint) error {
+	if err := g.check(ctx, DeleteUser, id); err != nil {
+		return err
+	}
+	return g.repo.Delete(ctx, id)
+}
+
+func (g *Guard) SetRole(ctx context.Context, id uint, role string) (models.User, error) {
+	if err := g.check(ctx, AssignRole, id); err != nil {
+		return models.User{}, err
+	}
+	if id == g.caller.UserID {
+		// Keeps the last admin from locking everyone out
+		return models.User{}, apperr.Forbidden("callers cannot change their own role")
+	}
+	if _, ok := Roles[role]; !ok {
+		return models.User{}, apperr.Validation("The request body is invalid",
+			apperr.FieldError{Field: "role", Message: fmt.Sprintf("must be %s or %s", models.RoleUser, models.RoleAdmin)})
+	}
+	return g.repo.SetRole(ctx, id, role)
+}
+
+func (g *Guard) GetProfile(ctx context.Context, userID uint) (models.Profile, error) {
+	if err := g.check(ctx, ReadProfile, userID); err != nil {
+		return models.Profile{}, err
+	}
+	return g.repo.GetProfile(ctx, userID)
+}
+
+func (g *Guard) SaveProfile(ctx context.Context, userID uint, profile models.Profile) (models.Profile, error) {
+	if err := g.check(ctx, WriteProfile, userID); err != nil {
+		return models.Profile{}, err
+	}
+	return g.repo.SaveProfile(ctx, userID, profile)
+}
+
+// Bootstrap creates an admin called name with password, so that a fresh
+// installation has someone who can assign roles. An existing admin of that
+// name is left as it is. An existing user is not promoted, since anyone can
+// register a name before the server is configured; Bootstrap then fails.
+func Bootstrap(ctx context.Context, repo repository.UserRepository, name, password string) error {
+	user, err := repo.GetByName(ctx, name)
+	switch {
+	case err == nil && user.Role == models.RoleAdmin:
+		return nil
+	case err == nil:
+		return apperr.Conflict(fmt.Sprintf("%q is an existing account without the admin role; choose another name", name))
+	case !errors.Is(err, apperr.ErrNotFound):
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return repo.Create(ctx, &models.User{Name: name, Role: models.RoleAdmin, PasswordHash: hash})
+}
@@ -0,0 +1,67 @@
+// Package problem writes errors as RFC 7807 application/problem+json
+// responses, for both the Gin and the net/http server.
+package problem
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"assignment2/apperr"
+	"assignment2/logging"
+	"assignment2/redact"
+)
+
+// ContentType is the media type of problem responses
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes the error code to form the problem type URI
+const TypeBase = "/problems/"
+
+// Problem is an RFC 7807 problem details object. Code, Errors and RequestID
+// are extension members: the stable error code, the per-field errors and the
+// ID under which the request was logged, for reporting server errors.
+type Problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail,omitempty"`
+	Instance  string              `json:"instance,omitempty"`
+	Code      apperr.Code         `json:"code"`
+	Errors    []apperr.FieldError `json:"errors,omitempty"`
+	RequestID string              `json:"request_id,omitempty"`
+}
+
+// New builds the problem for err that occurred while serving instance
+func New(err error, instance string) Problem {
+	e := apperr.From(err)
+	return Problem{
+		Type:     TypeBase + strings.ReplaceAll(string(e.Code), "_", "-"),
+		Title:    e.Code.Title(),
+		Status:   e.Code.Status(),
+		Detail:   e.Detail(),
+		Instance: instance,
+		Code:     e.Code,
+		Errors:   e.Fields,
+	}
+}
+
+// Write sends err as a problem response to r. Internal errors reach the
+// client only as their generic message; the full error is logged with the
+// request ID that the response carries.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	p := New(err, r.URL.RequestURI())
+	p.RequestID = logging.RequestID(r.Context())
+	if e := apperr.From(err); e.Err != nil && p.Status >= http.StatusInternalServerError {
+		level := slog.LevelWarn
+		if e.Code == apperr.CodeInternal {
+			level = slog.LevelError
+		}
+		logging.FromContext(r.Context()).LogAttrs(r.Context(), level, "Request failed",
+			slog.String("code", string(e.Code)), slog.Any("err", redact.Error(err)))
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,90 @@
+package problem
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+
+	"assignment2/apperr"
+	"assignment2/logging"
+	"assignment2/redact"
+)
+
+// Recover turns panics in next into 500 problem responses. The panic is
+// logged once, with its stack trace and with personal data in the panic
+// value redacted like in every other log line. A response that was already
+// started is left as it is, since its status has been sent.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &writeRecorder{ResponseWriter: w}
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logPanic(r, v)
+				if !rec.written {
+					Write(w, r, apperr.Wrap("internal error", nil))
+				}
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// GinRecover is Recover for Gin, replacing gin.Recovery
+func GinRecover() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logPanic(c.Request, v)
+				if !c.Writer.Written() {
+					Write(c.Writer, c.Request, apperr.Wrap("internal error", nil))
+				}
+				c.Abort()
+			}
+		}()
+		c.Next()
+	}
+}
+
+// writeRecorder records whether a response was started
+type writeRecorder struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *writeRecorder) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writeRecorder) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *writeRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func logPanic(r *http.Request, v interface{}) {
+	var value slog.Value
+	switch v := v.(type) {
+	case error:
+		value = redact.Error(v)
+	case string:
+		value = slog.StringValue(redact.Text(v))
+	default:
+		// Models implement slog.LogValuer and redact themselves
+		value = slog.AnyValue(v)
+	}
+	logging.FromContext(r.Context()).LogAttrs(r.Context(), slog.LevelError, "Panic serving request",
+		slog.Attr{Key: "panic", Value: value}, slog.String("stack", string(debug.Stack())))
+}
@@ -0,0 +1,279 @@
+// Package querybuilder turns user list query parameters into parameterized
+// SQL fragments and the equivalent GORM scopes, so the raw SQL and GORM
+// handlers filter and sort users the same way.
+package querybuilder
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Sortable columns, keyed by the name clients use in the sort parameter
+var sortColumns = map[string]string{
+	"id":   "id",
+	"name": "name",
+	"age":  "age",
+}
+
+// SortField is a single ORDER BY term
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// Keyset positions a query just after (or, when Backward, just before) the
+// row whose sort column values are Values, in SortFields order.
+type Keyset struct {
+	Values   []interface{}
+	Backward bool
+}
+
+// ListParams holds the structured filters and sort order for listing users
+type ListParams struct {
+	AgeMin       *int
+	AgeMax       *int
+	NamePrefix   string
+	NameContains string
+	Sort         []SortField
+	Keyset       *Keyset
+	// ID limits the list to one user; it is set by the policy layer, not by clients
+	ID *uint
+}
+
+// condition is one parameterized WHERE term shared by the SQL and GORM paths
+type condition struct {
+	clause string
+	args   []interface{}
+}
+
+// Parse reads age_min, age_max, name_prefix, name_contains and sort from the
+// query string. The legacy age (exact match) and sort=asc|desc (by name)
+// forms are still accepted.
+func Parse(q url.Values) (ListParams, error) {
+	var p ListParams
+
+	if v := q.Get("age"); v != "" {
+		age, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid age %q", v)
+		}
+		p.AgeMin, p.AgeMax = &age, &age
+	}
+	if v := q.Get("age_min"); v != "" {
+		age, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid age_min %q", v)
+		}
+		p.AgeMin = &age
+	}
+	if v := q.Get("age_max"); v != "" {
+		age, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid age_max %q", v)
+		}
+		p.AgeMax = &age
+	}
+	p.NamePrefix = q.Get("name_prefix")
+	p.NameContains = q.Get("name_contains")
+
+	sort, err := parseSort(q.Get("sort"))
+	if err != nil {
+		return p, err
+	}
+	p.Sort = sort
+	return p, nil
+}
+
+// Parses a comma separated list of sort keys, where a leading "-" means descending
+func parseSort(s string) ([]SortField, error) {
+	switch s {
+	case "":
+		return nil, nil
+	case "asc":
+		return []SortField{{Column: "name"}}, nil
+	case "desc":
+		return []SortField{{Column: "name", Desc: true}}, nil
+	}
+
+	var fields []SortField
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		desc := strings.HasPrefix(key, "-")
+		key = strings.TrimPrefix(strings.TrimPrefix(key, "-"), "+")
+		column, ok := sortColumns[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid sort key %q", key)
+		}
+		fields = append(fields, SortField{Column: column, Desc: desc})
+	}
+	return fields, nil
+}
+
+// Escapes the LIKE wildcards so user input only ever matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Returns the filter conditions, without the keyset
+func (p ListParams) filterConditions() []condition {
+	var conds []condition
+	if p.ID != nil {
+		conds = append(conds, condition{"id = ?", []interface{}{*p.ID}})
+	}
+	if p.AgeMin != nil {
+		conds = append(conds, condition{"age >= ?", []interface{}{*p.AgeMin}})
+	}
+	if p.AgeMax != nil {
+		conds = append(conds, condition{"age <= ?", []interface{}{*p.AgeMax}})
+	}
+	if p.NamePrefix != "" {
+		conds = append(conds, condition{"name LIKE ?", []interface{}{escapeLike(p.NamePrefix) + "%"}})
+	}
+	if p.NameContains != "" {
+		conds = append(conds, condition{"name LIKE ?", []interface{}{"%" + escapeLike(p.NameContains) + "%"}})
+	}
+	return conds
+}
+
+// Returns the filter conditions followed by the keyset condition, if any
+func (p ListParams) conditions() []condition {
+	conds := p.filterConditions()
+	if c, ok := p.keysetCondition(); ok {
+		conds = append(conds, c)
+	}
+	return conds
+}
+
+// SortFields returns the requested sort fields, always ending with id so
+// that rows come back in the same order on every call.
+func (p ListParams) SortFields() []SortField {
+	fields := append([]SortField(nil), p.Sort...)
+	for _, f := range fields {
+		if f.Column == "id" {
+			return fields
+		}
+	}
+	return append(fields, SortField{Column: "id"})
+}
+
+// SortKey identifies the sort order, so a keyset taken under one order is
+// not applied to another.
+func (p ListParams) SortKey() string {
+	var keys []string
+	for _, f := range p.SortFields() {
+		if f.Desc {
+			keys = append(keys, "-"+f.Column)
+		} else {
+			keys = append(keys, f.Column)
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// Builds the row-value comparison for the keyset as an OR of prefixes, e.g.
+// (name > ?) OR (name = ? AND id > ?), since columns may sort in different directions.
+func (p ListParams) keysetCondition() (condition, bool) {
+	fields := p.SortFields()
+	if p.Keyset == nil || len(p.Keyset.Values) != len(fields) {
+		return condition{}, false
+	}
+
+	var terms []string
+	var args []interface{}
+	for i, f := range fields {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fields[j].Column+" = ?")
+			args = append(args, p.Keyset.Values[j])
+		}
+		op := ">"
+		if f.Desc != p.Keyset.Backward {
+			op = "<"
+		}
+		parts = append(parts, f.Column+" "+op+" ?")
+		args = append(args, p.Keyset.Values[i])
+		terms = append(terms, "("+strings.Join(parts, " AND ")+")")
+	}
+	return condition{"(" + strings.Join(terms, " OR ") + ")", args}, true
+}
+
+// OrderTerms returns the ORDER BY terms. A backward keyset reverses every
+// direction; callers reverse the fetched rows to restore the requested order.
+func (p ListParams) OrderTerms() []string {
+	backward := p.Keyset != nil && p.Keyset.Backward
+	var terms []string
+	for _, f := range p.SortFields() {
+		if f.Desc != backward {
+			terms = append(terms, f.Column+" DESC")
+		} else {
+			terms = append(terms, f.Column+" ASC")
+		}
+	}
+	return terms
+}
+
+// Appends " WHERE ..." for conds to sb and returns their arguments
+func writeWhere(sb *strings.Builder, conds []condition) []interface{} {
+	var args []interface{}
+	for i, c := range conds {
+		if i == 0 {
+			sb.WriteString(" WHERE ")
+		} else {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString(c.clause)
+		args = append(args, c.args...)
+	}
+	return args
+}
+
+// Build appends the WHERE and ORDER BY clauses to base and returns the
+// statement together with its arguments.
+func (p ListParams) Build(base string) (string, []interface{}) {
+	var sb strings.Builder
+	sb.WriteString(base)
+	args := writeWhere(&sb, p.conditions())
+
+	sb.WriteString(" ORDER BY ")
+	sb.WriteString(strings.Join(p.OrderTerms(), ", "))
+	return sb.String(), args
+}
+
+// BuildCount appends only the filter conditions to base, ignoring the keyset
+// and sort order, for use with a COUNT(*) statement.
+func (p ListParams) BuildCount(base string) (string, []interface{}) {
+	var sb strings.Builder
+	sb.WriteString(base)
+	args := writeWhere(&sb, p.filterConditions())
+	return sb.String(), args
+}
+
+// Scopes returns GORM scopes applying the same filters and order as Build
+func (p ListParams) Scopes() []func(*gorm.DB) *gorm.DB {
+	scopes := conditionScopes(p.conditions())
+	for _, term := range p.OrderTerms() {
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB {
+			return db.Order(term)
+		})
+	}
+	return scopes
+}
+
+// CountScopes returns GORM scopes applying the same filters as BuildCount
+func (p ListParams) CountScopes() []func(*gorm.DB) *gorm.DB {
+	return conditionScopes(p.filterConditions())
+}
+
+func conditionScopes(conds []condition) []func(*gorm.DB) *gorm.DB {
+	var scopes []func(*gorm.DB) *gorm.DB
+	for _, c := range conds {
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB {
+			return db.Where(c.clause, c.args...)
+		})
+	}
+	return scopes
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	s := NewMemoryStore()
+	rule := Rule{Requests: 2, Period: time.Second, Burst: 3}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		want    Result
+	}{
+		{"full bucket", 0, Result{Allowed: true, Remaining: 2, Reset: 500 * time.Millisecond}},
+		{"second", 0, Result{Allowed: true, Remaining: 1, Reset: time.Second}},
+		{"third", 0, Result{Allowed: true, Remaining: 0, Reset: 1500 * time.Millisecond}},
+		{"empty", 0, Result{Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+		{"half a token later", 250 * time.Millisecond, Result{Remaining: 0, Reset: 1250 * time.Millisecond, RetryAfter: 250 * time.Millisecond}},
+		{"a token later", 250 * time.Millisecond, Result{Allowed: true, Remaining: 0, Reset: 1500 * time.Millisecond}},
+		{"refilled, not beyond the burst", time.Hour, Result{Allowed: true, Remaining: 2, Reset: 500 * time.Millisecond}},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		got, err := s.Take("client", rule, now)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: Take = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestMemoryStoreKeysAreSeparate(t *testing.T) {
+	s := NewMemoryStore()
+	rule := Rule{Requests: 1, Period: time.Minute, Burst: 1}
+	now := time.Now()
+	if res, _ := s.Take("a", rule, now); !res.Allowed {
+		t.Fatal("first request of a was not allowed")
+	}
+	if res, _ := s.Take("a", rule, now); res.Allowed {
+		t.Error("second request of a was allowed")
+	}
+	if res, _ := s.Take("b", rule, now); !res.Allowed {
+		t.Error("first request of b was not allowed")
+	}
+}
+
+func TestMemoryStoreSweepsRefilledBuckets(t *testing.T) {
+	s := NewMemoryStore()
+	rule := Rule{Requests: 1, Period: time.Second, Burst: 1}
+	now := time.Now()
+	s.Take("a", rule, now)
+	s.Take("b", rule, now.Add(sweepInterval))
+	if _, ok := s.buckets["a"]; ok {
+		t.Error("refilled bucket a was kept")
+	}
+	if _, ok := s.buckets["b"]; !ok {
+		t.Error("bucket b was dropped")
+	}
+}
@@ -0,0 +1,172 @@
+// Package ratelimit limits the request rate of each client with token
+// buckets, for both the Gin and the net/http server.
+//
+// Clients are identified by their API key or user once authenticated, and
+// otherwise by IP address. Routes that authenticate use the middleware both
+// before the authentication middleware and after it: requests are charged
+// to the IP address first, so that a client sending made-up credentials is
+// stopped before each one is looked up in the database, and then to the
+// caller. Every route has its own bucket per client when it has a rule of
+// its own; the other routes share the default rule's bucket.
+//
+// Responses carry the RateLimit-Policy, RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers of draft-ietf-httpapi-ratelimit-headers.
+// Rejected requests get a 429 problem with Retry-After.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"assignment2/apperr"
+	"assignment2/auth"
+	"assignment2/config"
+	"assignment2/logging"
+	"assignment2/problem"
+)
+
+// Rule allows Requests per Period on average, in bursts of up to Burst
+type Rule struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// RuleFrom converts a configured rule, defaulting Burst to Requests
+func RuleFrom(r config.RateLimitRule) Rule {
+	rule := Rule{Requests: r.Requests, Period: r.Per.Duration, Burst: r.Burst}
+	if rule.Burst == 0 {
+		rule.Burst = rule.Requests
+	}
+	return rule
+}
+
+// Tokens added to the bucket per second
+func (r Rule) rate() float64 {
+	return float64(r.Requests) / r.Period.Seconds()
+}
+
+// Result is the state of a bucket after taking a token from it
+type Result struct {
+	Allowed   bool
+	Remaining int           // whole tokens left
+	Reset     time.Duration // until the bucket is full again
+	// RetryAfter is the wait until the next token, if the request was not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets. It must be safe for concurrent use; a
+// shared store lets several instances of a server enforce one limit.
+type Store interface {
+	// Take takes a token from the bucket for key, which is governed by rule
+	Take(key string, rule Rule, now time.Time) (Result, error)
+}
+
+// Limiter applies the configured rules to requests
+type Limiter struct {
+	store   Store
+	def     Rule
+	routes  map[string]Rule
+	proxies []netip.Prefix
+	now     func() time.Time
+}
+
+// New returns a limiter for cfg that keeps its buckets in store, or in a
+// MemoryStore if store is nil
+func New(cfg config.RateLimitConfig, store Store) (*Limiter, error) {
+	proxies, err := cfg.Proxies()
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	l := &Limiter{
+		store:   store,
+		def:     RuleFrom(cfg.Default),
+		routes:  make(map[string]Rule, len(cfg.Routes)),
+		proxies: proxies,
+		now:     time.Now,
+	}
+	for route, r := range cfg.Routes {
+		l.routes[route] = RuleFrom(r)
+	}
+	return l, nil
+}
+
+// Middleware limits requests to the route the ServeMux matched, e.g.
+// "GET /sql/users/{id}"
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := l.allow(w, r, r.Pattern); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GinMiddleware is Middleware for Gin, where routes are written like
+// "GET /gorm/user/:id"
+func (l *Limiter) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := l.allow(c.Writer, c.Request, c.Request.Method+" "+c.FullPath()); err != nil {
+			problem.Write(c.Writer, c.Request, err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Takes a token for the client of r and sets the rate limit headers
+func (l *Limiter) allow(w http.ResponseWriter, r *http.Request, route string) error {
+	rule, ok := l.routes[route]
+	if !ok {
+		route, rule = "*", l.def
+	}
+	if rule.Requests <= 0 {
+		return nil
+	}
+
+	res, err := l.store.Take(route+" "+l.client(r), rule, l.now())
+	if err != nil {
+		// An unavailable store must not take the API down with it
+		logging.FromContext(r.Context()).Error("Rate limit store failed", "err", err)
+		return nil
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", rule.Requests, seconds(rule.Period), rule.Burst))
+	h.Set("RateLimit-Limit", strconv.Itoa(rule.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+	if res.Allowed {
+		return nil
+	}
+	retry := seconds(res.RetryAfter)
+	h.Set("Retry-After", strconv.Itoa(retry))
+	return apperr.RateLimited(fmt.Sprintf("rate limit exceeded, retry in %d seconds", retry))
+}
+
+// Identifies the client of r
+func (l *Limiter) client(r *http.Request) string {
+	if id, ok := auth.FromContext(r.Context()); ok {
+		if id.APIKeyID != 0 {
+			return "key:" + strconv.FormatUint(uint64(id.APIKeyID), 10)
+		}
+		return "user:" + strconv.FormatUint(uint64(id.UserID), 10)
+	}
+	return "ip:" + ClientIP(r, l.proxies)
+}
+
+// Rounds d up to whole seconds, as the headers require
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
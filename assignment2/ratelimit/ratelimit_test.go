@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"assignment2/auth"
+	"assignment2/config"
+)
+
+// Returns a limiter allowing 2 requests a minute by default and 1 on
+// "POST /login", on a clock that only moves when the test says so
+func newTestLimiter(t *testing.T) (*Limiter, *time.Time) {
+	t.Helper()
+	l, err := New(config.RateLimitConfig{
+		Default: config.RateLimitRule{Requests: 2, Per: config.Duration{Duration: time.Minute}},
+		Routes: map[string]config.RateLimitRule{
+			"POST /login": {Requests: 1, Per: config.Duration{Duration: time.Minute}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func send(handler http.Handler, method, path, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	r.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	mux := http.NewServeMux()
+	mux.Handle("GET /users", l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	rec := send(mux, http.MethodGet, "/users", "203.0.113.7:5000")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	want := map[string]string{
+		"RateLimit-Policy":    "2;w=60;burst=2",
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "1",
+		"RateLimit-Reset":     "30",
+		"Retry-After":         "",
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	send(mux, http.MethodGet, "/users", "203.0.113.7:5000")
+	rec = send(mux, http.MethodGet, "/users", "203.0.113.7:5000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status over the limit = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	for name, value := range map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "60", "Retry-After": "30"} {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("over the limit: %s = %q, want %q", name, got, value)
+		}
+	}
+
+	// Another address has its own budget
+	if rec := send(mux, http.MethodGet, "/users", "198.51.100.1:5000"); rec.Code != http.StatusOK {
+		t.Errorf("status for another address = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMiddlewareRouteRules(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	mux := http.NewServeMux()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux.Handle("POST /login", l.Middleware(ok))
+	mux.Handle("GET /users", l.Middleware(ok))
+	mux.Handle("GET /items", l.Middleware(ok))
+
+	if rec := send(mux, http.MethodPost, "/login", "203.0.113.7:5000"); rec.Code != http.StatusOK {
+		t.Fatalf("first login = %d", rec.Code)
+	}
+	if rec := send(mux, http.MethodPost, "/login", "203.0.113.7:5000"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second login = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	// Routes without a rule share the default budget, apart from the login's
+	send(mux, http.MethodGet, "/users", "203.0.113.7:5000")
+	send(mux, http.MethodGet, "/items", "203.0.113.7:5000")
+	if rec := send(mux, http.MethodGet, "/users", "203.0.113.7:5000"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("third request on the default rule = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestMiddlewareKeysByCaller(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	as := func(id auth.Identity, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), id)))
+		})
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle("GET /user", as(auth.Identity{UserID: 1}, l.Middleware(ok)))
+	mux.Handle("GET /key", as(auth.Identity{UserID: 1, APIKeyID: 9}, l.Middleware(ok)))
+
+	// The user and its API key have separate budgets, wherever they call from
+	for _, path := range []string{"/user", "/key"} {
+		send(mux, http.MethodGet, path, "203.0.113.7:5000")
+		send(mux, http.MethodGet, path, "198.51.100.1:5000")
+		if rec := send(mux, http.MethodGet, path, "192.0.2.1:5000"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: third request = %d, want %d", path, rec.Code, http.StatusTooManyRequests)
+		}
+	}
+}
+
+// Made-up credentials are charged to the IP address before they are checked,
+// so guessing stops reaching the authentication middleware
+func TestMiddlewareBeforeAuthentication(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	checked := 0
+	reject := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			checked++
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /users", l.Middleware(reject(l.Middleware(http.NotFoundHandler()))))
+
+	for i := 0; i < 5; i++ {
+		send(mux, http.MethodGet, "/users", "203.0.113.7:5000")
+	}
+	if checked != 2 {
+		t.Errorf("credentials checked %d times, want 2", checked)
+	}
+}
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, now := newTestLimiter(t)
+	router := gin.New()
+	router.POST("/login", l.GinMiddleware(), func(c *gin.Context) {})
+
+	if rec := send(router, http.MethodPost, "/login", "203.0.113.7:5000"); rec.Code != http.StatusOK {
+		t.Fatalf("first login = %d", rec.Code)
+	}
+	rec := send(router, http.MethodPost, "/login", "203.0.113.7:5000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second login = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want %q", got, "60")
+	}
+	*now = now.Add(time.Minute)
+	if rec := send(router, http.MethodPost, "/login", "203.0.113.7:5000"); rec.Code != http.StatusOK {
+		t.Errorf("login a minute later = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,116 @@
+// Package redact keeps personal data out of logs.
+//
+// Struct fields tagged `pii:"mask"` are logged as Mask. Fields tagged
+// `pii:"hash"` are logged as a short keyed hash, so that lines about the same
+// person can still be correlated without revealing who it is. Fields that
+// are not encoded as JSON, such as password hashes, are left out entirely.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces masked values
+const Mask = "[REDACTED]"
+
+// Keys the hashes; it changes on every start, so hashes cannot be compared
+// across restarts or looked up in a precomputed table
+var key = func() []byte {
+	k := make([]byte, 32)
+	rand.Read(k)
+	return k
+}()
+
+// Hash returns a short keyed hash of s, or "" for an empty string
+func Hash(s string) string {
+	if s == "" {
+		return ""
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return "h:" + hex.EncodeToString(m.Sum(nil)[:6])
+}
+
+// Value returns v, a struct or pointer to struct, as a slog group of its
+// JSON fields with the pii tags applied. Nested structs are redacted too.
+func Value(v interface{}) slog.Value {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return slog.AnyValue(nil)
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return slog.AnyValue(v)
+	}
+	return structValue(rv)
+}
+
+func structValue(rv reflect.Value) slog.Value {
+	rt := rv.Type()
+	attrs := make([]slog.Attr, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if !sf.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		fv := rv.Field(i)
+		switch sf.Tag.Get("pii") {
+		case "mask":
+			if !fv.IsZero() {
+				attrs = append(attrs, slog.String(name, Mask))
+			}
+			continue
+		case "hash":
+			attrs = append(attrs, slog.String(name, Hash(fv.String())))
+			continue
+		}
+		// Types with a LogValue method, such as nested models, redact themselves
+		if _, ok := fv.Interface().(slog.LogValuer); !ok && fv.Kind() == reflect.Struct && hasPII(fv.Type()) {
+			attrs = append(attrs, slog.Attr{Key: name, Value: structValue(fv)})
+			continue
+		}
+		attrs = append(attrs, slog.Any(name, fv.Interface()))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func hasPII(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("pii") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Values that MySQL quotes in its error messages, e.g.
+// "Duplicate entry 'bob' for key 'users.name'"
+var quotedValueRe = regexp.MustCompile(`(entry|value:?) '[^']*'`)
+
+// Text masks the values that database errors quote, so that error messages
+// can be logged without the data that caused them
+func Text(s string) string {
+	return quotedValueRe.ReplaceAllString(s, "$1 '"+Mask+"'")
+}
+
+// Error is err for logging: its message with Text applied
+func Error(err error) slog.Value {
+	if err == nil {
+		return slog.AnyValue(nil)
+	}
+	return slog.StringValue(Text(err.Error()))
+}
@@ -0,0 +1,316 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"assignment2/dberr"
+	"assignment2/models"
+	"assignment2/querybuilder"
+	"assignment2/txn"
+)
+
+// GORMRepository implements UserRepository with GORM
+type GORMRepository struct {
+	db *gorm.DB
+}
+
+// NewGORMRepository returns a repository using db
+func NewGORMRepository(db *gorm.DB) *GORMRepository {
+	return &GORMRepository{db: db}
+}
+
+// Translates GORM's not-found error into notFound and classifies MySQL errors
+func gormError(err, notFound error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return notFound
+	}
+	return dberr.Classify(err)
+}
+
+func (r *GORMRepository) Create(ctx context.Context, user *models.User) error {
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	// The user and a non-empty profile are inserted together; GORM would
+	// otherwise store a profile that only carries the user ID
+	return dberr.Classify(txn.WithGORMTx(ctx, r.db, nil, func(tx *gorm.DB) error {
+		// A retry inserts afresh
+		user.ID, user.Profile.ID = 0, 0
+		if user.Profile.IsZero() {
+			user.Profile.UserID = 0
+			return tx.Omit("Profile").Create(user).Error
+		}
+		return tx.Create(user).Error
+	}))
+}
+
+func (r *GORMRepository) Get(ctx context.Context, id uint) (models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Preload("Profile").First(&user, id).Error
+	return user, gormError(err, userNotFound(id))
+}
+
+func (r *GORMRepository) GetByName(ctx context.Context, name string) (models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Where("name = ?", name).First(&user).Error
+	return user, gormError(err, userNameNotFound(name))
+}
+
+func (r *GORMRepository) List(ctx context.Context, params querybuilder.ListParams, limit, offset int) ([]models.User, error) {
+	var users []models.User
+	err := r.db.WithContext(ctx).Scopes(params.Scopes()...).Limit(limit).Offset(offset).Find(&users).Error
+	return users, dberr.Classify(err)
+}
+
+func (r *GORMRepository) Count(ctx context.Context, params querybuilder.ListParams) (int64, error) {
+	var total int64
+	err := r.db.WithContext(ctx).Model(&models.User{}).Scopes(params.CountScopes()...).Count(&total).Error
+	return total, dberr.Classify(err)
+}
+
+func (r *GORMRepository) Update(ctx context.Context, id uint, update UserUpdate) (models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		return user, gormError(err, userNotFound(id))
+	}
+
+	// A map is used so that zero values (e.g. age 0) are written too
+	updates := map[string]interface{}{}
+	if update.Name != nil {
+		updates["name"] = *update.Name
+	}
+	if update.Age != nil {
+		updates["age"] = *update.Age
+	}
+	if len(updates) > 0 {
+		if err := r.db.WithContext(ctx).Model(&user).Updates(updates).Error; err != nil {
+			return user, dberr.Classify(err)
+		}
+	}
+	return r.Get(ctx, id)
+}
+
+func (r *GORMRepository) Delete(ctx context.Context, id uint) error {
+	err := txn.WithGORMTx(ctx, r.db, nil, func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&models.Profile{}).Error; err != nil {
+			return err
+		}
+		if err := deleteCredentialsGORM(tx, id); err != nil {
+			return err
+		}
+		result := tx.Delete(&models.User{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return userNotFound(id)
+		}
+		return nil
+	})
+	return dberr.Classify(err)
+}
+
+// Deletes the user's refresh tokens, API keys and 2FA enrollment, so that
+// nothing issued to a deleted user can still be used
+func deleteCredentialsGORM(tx *gorm.DB, userID uint) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.APIKey{}).Error; err != nil {
+		return err
+	}
+	return deleteTwoFactorGORM(tx, userID)
+}
+
+func (r *GORMRepository) SetRole(ctx context.Context, id uint, role string) (models.User, error) {
+	if err := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("role", role).Error; err != nil {
+		return models.User{}, dberr.Classify(err)
+	}
+	return r.Get(ctx, id)
+}
+
+func (r *GORMRepository) GetProfile(ctx context.Context, userID uint) (models.Profile, error) {
+	var profile models.Profile
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&profile).Error
+	return profile, gormError(err, profileNotFound(userID))
+}
+
+func (r *GORMRepository) SaveProfile(ctx context.Context, userID uint, profile models.Profile) (models.Profile, error) {
+	if err := r.db.WithContext(ctx).Select("id").First(&models.User{}, userID).Error; err != nil {
+		return profile, gormError(err, userNotFound(userID))
+	}
+
+	var saved models.Profile
+	err := r.db.WithContext(ctx).Where(models.Profile{UserID: userID}).
+		Assign(map[string]interface{}{"bio": profile.Bio, "profile_picture_url": profile.ProfilePictureURL}).
+		FirstOrCreate(&saved).Error
+	return saved, dberr.Classify(err)
+}
+
+// GORMTokenRepository implements TokenRepository with GORM
+type GORMTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewGORMTokenRepository returns a token repository using db
+func NewGORMTokenRepository(db *gorm.DB) *GORMTokenRepository {
+	return &GORMTokenRepository{db: db}
+}
+
+func (r *GORMTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	return dberr.Classify(r.db.WithContext(ctx).Create(token).Error)
+}
+
+func (r *GORMTokenRepository) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.WithContext(ctx).Where("hash = ?", hash).First(&token).Error
+	return token, gormError(err, refreshTokenNotFound())
+}
+
+func (r *GORMTokenRepository) RevokeRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at)
+	return result.RowsAffected == 1, dberr.Classify(result.Error)
+}
+
+func (r *GORMTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID uint, at time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+	return dberr.Classify(err)
+}
+
+func (r *GORMTokenRepository) RevokeAccessToken(ctx context.Context, token models.RevokedToken) error {
+	// Expired tokens are rejected anyway, so their rows are purged as new
+	// ones come in
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		return dberr.Classify(err)
+	}
+	// Revoking the same token twice is not an error
+	return dberr.Classify(r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&token).Error)
+}
+
+func (r *GORMTokenRepository) IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.RevokedToken{}).Where("id = ?", id).Count(&count).Error
+	return count > 0, dberr.Classify(err)
+}
+
+func (r *GORMTokenRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	return dberr.Classify(r.db.WithContext(ctx).Create(key).Error)
+}
+
+func (r *GORMTokenRepository) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.WithContext(ctx).Where("hash = ?", hash).First(&key).Error
+	return key, gormError(err, apiKeyHashNotFound())
+}
+
+func (r *GORMTokenRepository) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id DESC").Find(&keys).Error
+	return keys, dberr.Classify(err)
+}
+
+func (r *GORMTokenRepository) RevokeAPIKey(ctx context.Context, userID, id uint, at time.Time) error {
+	var key models.APIKey
+	if err := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&key).Error; err != nil {
+		return gormError(err, apiKeyNotFound(id))
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+	return dberr.Classify(r.db.WithContext(ctx).Model(&key).Update("revoked_at", at).Error)
+}
+
+func (r *GORMTokenRepository) TouchAPIKey(ctx context.Context, id uint, at time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Update("last_used_at", at).Error
+	return dberr.Classify(err)
+}
+
+func (r *GORMTokenRepository) GetTwoFactor(ctx context.Context, userID uint) (models.TwoFactor, error) {
+	var tf models.TwoFactor
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&tf).Error
+	return tf, gormError(err, twoFactorNotFound(userID))
+}
+
+func (r *GORMTokenRepository) SaveTwoFactor(ctx context.Context, tf *models.TwoFactor) error {
+	err := txn.WithGORMTx(ctx, r.db, nil, func(tx *gorm.DB) error {
+		if err := deleteTwoFactorGORM(tx, tf.UserID); err != nil {
+			return err
+		}
+		tf.ID = 0 // a retry inserts afresh
+		return tx.Create(tf).Error
+	})
+	return dberr.Classify(err)
+}
+
+// Deletes the user's enrollment and recovery codes
+func deleteTwoFactorGORM(tx *gorm.DB, userID uint) error {
+	enrollment := tx.Model(&models.TwoFactor{}).Select("id").Where("user_id = ?", userID)
+	if err := tx.Where("two_factor_id IN (?)", enrollment).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&models.TwoFactor{}).Error
+}
+
+func (r *GORMTokenRepository) ConfirmTwoFactor(ctx context.Context, id uint, step int64, at time.Time, codes []models.RecoveryCode) error {
+	err := txn.WithGORMTx(ctx, r.db, nil, func(tx *gorm.DB) error {
+		err := tx.Model(&models.TwoFactor{}).Where("id = ?", id).
+			Updates(map[string]interface{}{"confirmed_at": at, "last_used_step": step}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("two_factor_id = ?", id).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for i := range codes {
+			codes[i].ID = 0 // a retry inserts afresh
+			codes[i].TwoFactorID = id
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(&codes).Error
+	})
+	return dberr.Classify(err)
+}
+
+func (r *GORMTokenRepository) UseTOTPStep(ctx context.Context, id uint, step int64) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.TwoFactor{}).
+		Where("id = ? AND last_used_step < ?", id, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, dberr.Classify(result.Error)
+}
+
+func (r *GORMTokenRepository) UseRecoveryCode(ctx context.Context, twoFactorID uint, hash string, at time.Time) (bool, error) {
+	// GORM ignores Limit on updates, so the code is looked up first and
+	// updated by its primary key
+	db := r.db.WithContext(ctx)
+	var code models.RecoveryCode
+	err := db.Select("id").Where("two_factor_id = ? AND hash = ? AND used_at IS NULL", twoFactorID, hash).Take(&code).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, dberr.Classify(err)
+	}
+	// A concurrent login may have used the code in between
+	result := db.Model(&models.RecoveryCode{}).Where("id = ? AND used_at IS NULL", code.ID).Update("used_at", at)
+	return result.RowsAffected == 1, dberr.Classify(result.Error)
+}
+
+func (r *GORMTokenRepository) DeleteTwoFactor(ctx context.Context, userID uint) error {
+	if _, err := r.GetTwoFactor(ctx, userID); err != nil {
+		return err
+	}
+	return dberr.Classify(txn.WithGORMTx(ctx, r.db, nil, func(tx *gorm.DB) error {
+		return deleteTwoFactorGORM(tx, userID)
+	}))
+}
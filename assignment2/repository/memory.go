@@ -0,0 +1,563 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"assignment2/apperr"
+	"assignment2/models"
+	"assignment2/querybuilder"
+)
+
+// MemoryRepository is a thread-safe in-memory UserRepository for tests and
+// local development. It mirrors the MySQL schema: names are unique and
+// compared case-insensitively, like the default MySQL collation.
+type MemoryRepository struct {
+	mu        sync.RWMutex
+	users     map[uint]models.User
+	profiles  map[uint]models.Profile // keyed by user ID
+	tokens    *MemoryTokenRepository
+	nextID    uint
+	nextProID uint
+}
+
+// NewMemoryRepository returns an empty repository. When tokens is not nil,
+// Delete also removes the user's credentials from it, like the database
+// repositories do.
+func NewMemoryRepository(tokens *MemoryTokenRepository) *MemoryRepository {
+	return &MemoryRepository{
+		users:    map[uint]models.User{},
+		profiles: map[uint]models.Profile{},
+		tokens:   tokens,
+	}
+}
+
+// Returns an error if another user already has name; caller holds the lock
+func (r *MemoryRepository) checkUniqueName(name string, self uint) error {
+	for _, u := range r.users {
+		if u.ID != self && strings.EqualFold(u.Name, name) {
+			return apperr.Conflict(fmt.Sprintf("a user named %q already exists", name),
+				apperr.FieldError{Field: "name", Message: "must be unique"})
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkUniqueName(user.Name, 0); err != nil {
+		return err
+	}
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	r.nextID++
+	user.ID = r.nextID
+	if !user.Profile.IsZero() {
+		r.nextProID++
+		user.Profile.ID = r.nextProID
+		user.Profile.UserID = user.ID
+		r.profiles[user.ID] = user.Profile
+	}
+	stored := *user
+	stored.Profile = models.Profile{}
+	r.users[user.ID] = stored
+	return nil
+}
+
+func (r *MemoryRepository) Get(ctx context.Context, id uint) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return user, userNotFound(id)
+	}
+	user.Profile = r.profiles[id]
+	return user, nil
+}
+
+func (r *MemoryRepository) GetByName(ctx context.Context, name string) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if strings.EqualFold(u.Name, name) {
+			return u, nil
+		}
+	}
+	return models.User{}, userNameNotFound(name)
+}
+
+// Reports whether user passes the filters and keyset of params
+func matches(params querybuilder.ListParams, user models.User) bool {
+	if params.ID != nil && user.ID != *params.ID {
+		return false
+	}
+	if params.AgeMin != nil && user.Age < *params.AgeMin {
+		return false
+	}
+	if params.AgeMax != nil && user.Age > *params.AgeMax {
+		return false
+	}
+	name := strings.ToLower(user.Name)
+	if params.NamePrefix != "" && !strings.HasPrefix(name, strings.ToLower(params.NamePrefix)) {
+		return false
+	}
+	if params.NameContains != "" && !strings.Contains(name, strings.ToLower(params.NameContains)) {
+		return false
+	}
+	if params.Keyset != nil {
+		c := compareUsers(params.SortFields(), SortValues(params, user), params.Keyset.Values)
+		if params.Keyset.Backward {
+			return c < 0
+		}
+		return c > 0
+	}
+	return true
+}
+
+// Compares two rows' sort values in sort order, honouring each field's direction
+func compareUsers(fields []querybuilder.SortField, a, b []interface{}) int {
+	for i, f := range fields {
+		if i >= len(a) || i >= len(b) {
+			break
+		}
+		c := compareValues(a[i], b[i])
+		if f.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareValues(a, b interface{}) int {
+	as, aStr := a.(string)
+	bs, bStr := b.(string)
+	if aStr && bStr {
+		return strings.Compare(strings.ToLower(as), strings.ToLower(bs))
+	}
+	ai, bi := toInt64(a), toInt64(b)
+	switch {
+	case ai < bi:
+		return -1
+	case ai > bi:
+		return 1
+	}
+	return 0
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case uint:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+func (r *MemoryRepository) List(ctx context.Context, params querybuilder.ListParams, limit, offset int) ([]models.User, error) {
+	r.mu.RLock()
+	var users []models.User
+	for _, u := range r.users {
+		if matches(params, u) {
+			users = append(users, u)
+		}
+	}
+	r.mu.RUnlock()
+
+	fields := params.SortFields()
+	backward := params.Keyset != nil && params.Keyset.Backward
+	sort.Slice(users, func(i, j int) bool {
+		c := compareUsers(fields, SortValues(params, users[i]), SortValues(params, users[j]))
+		if backward {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if offset >= len(users) {
+		return nil, nil
+	}
+	users = users[offset:]
+	if limit >= 0 && limit < len(users) {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (r *MemoryRepository) Count(ctx context.Context, params querybuilder.ListParams) (int64, error) {
+	params.Keyset = nil
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var total int64
+	for _, u := range r.users {
+		if matches(params, u) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+func (r *MemoryRepository) Update(ctx context.Context, id uint, update UserUpdate) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return user, userNotFound(id)
+	}
+	if update.Name != nil {
+		if err := r.checkUniqueName(*update.Name, id); err != nil {
+			return user, err
+		}
+		user.Name = *update.Name
+	}
+	if update.Age != nil {
+		user.Age = *update.Age
+	}
+	r.users[id] = user
+	user.Profile = r.profiles[id]
+	return user, nil
+}
+
+func (r *MemoryRepository) Delete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return userNotFound(id)
+	}
+	delete(r.users, id)
+	delete(r.profiles, id)
+	if r.tokens != nil {
+		r.tokens.deleteUser(id)
+	}
+	return nil
+}
+
+func (r *MemoryRepository) SetRole(ctx context.Context, id uint, role string) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return user, userNotFound(id)
+	}
+	user.Role = role
+	r.users[id] = user
+	user.Profile = r.profiles[id]
+	return user, nil
+}
+
+func (r *MemoryRepository) GetProfile(ctx context.Context, userID uint) (models.Profile, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	profile, ok := r.profiles[userID]
+	if !ok {
+		return profile, profileNotFound(userID)
+	}
+	return profile, nil
+}
+
+func (r *MemoryRepository) SaveProfile(ctx context.Context, userID uint, profile models.Profile) (models.Profile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[userID]; !ok {
+		return profile, userNotFound(userID)
+	}
+	existing, ok := r.profiles[userID]
+	if ok {
+		profile.ID = existing.ID
+	} else {
+		r.nextProID++
+		profile.ID = r.nextProID
+	}
+	profile.UserID = userID
+	r.profiles[userID] = profile
+	return profile, nil
+}
+
+// MemoryTokenRepository is a thread-safe in-memory TokenRepository
+type MemoryTokenRepository struct {
+	mu        sync.Mutex
+	refresh   map[uint]models.RefreshToken
+	revoked   map[string]models.RevokedToken
+	apiKeys   map[uint]models.APIKey
+	twoFactor map[uint]models.TwoFactor // keyed by user ID, with recovery codes
+	nextID    uint
+	nextKeyID uint
+	nextTFID  uint
+}
+
+// NewMemoryTokenRepository returns an empty token repository
+func NewMemoryTokenRepository() *MemoryTokenRepository {
+	return &MemoryTokenRepository{
+		refresh:   map[uint]models.RefreshToken{},
+		revoked:   map[string]models.RevokedToken{},
+		apiKeys:   map[uint]models.APIKey{},
+		twoFactor: map[uint]models.TwoFactor{},
+	}
+}
+
+// Removes the refresh tokens, API keys and 2FA enrollment of a deleted user
+func (r *MemoryTokenRepository) deleteUser(userID uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.refresh {
+		if t.UserID == userID {
+			delete(r.refresh, id)
+		}
+	}
+	for id, k := range r.apiKeys {
+		if k.UserID == userID {
+			delete(r.apiKeys, id)
+		}
+	}
+	delete(r.twoFactor, userID)
+}
+
+func (r *MemoryTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	token.ID = r.nextID
+	r.refresh[token.ID] = *token
+	return nil
+}
+
+func (r *MemoryTokenRepository) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.refresh {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+	return models.RefreshToken{}, refreshTokenNotFound()
+}
+
+func (r *MemoryTokenRepository) RevokeRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.refresh[id]
+	if !ok || t.RevokedAt != nil {
+		return false, nil
+	}
+	t.RevokedAt = &at
+	r.refresh[id] = t
+	return true, nil
+}
+
+func (r *MemoryTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.refresh {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &at
+			r.refresh[id] = t
+		}
+	}
+	return nil
+}
+
+func (r *MemoryTokenRepository) RevokeAccessToken(ctx context.Context, token models.RevokedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, t := range r.revoked {
+		if t.ExpiresAt.Before(now) {
+			delete(r.revoked, id)
+		}
+	}
+	r.revoked[token.ID] = token
+	return nil
+}
+
+func (r *MemoryTokenRepository) IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.revoked[id]
+	return ok, nil
+}
+
+func (r *MemoryTokenRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextKeyID++
+	key.ID = r.nextKeyID
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	r.apiKeys[key.ID] = *key
+	return nil
+}
+
+func (r *MemoryTokenRepository) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.apiKeys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return models.APIKey{}, apiKeyHashNotFound()
+}
+
+func (r *MemoryTokenRepository) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []models.APIKey
+	for _, k := range r.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func (r *MemoryTokenRepository) RevokeAPIKey(ctx context.Context, userID, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.apiKeys[id]
+	if !ok || k.UserID != userID {
+		return apiKeyNotFound(id)
+	}
+	if k.RevokedAt == nil {
+		k.RevokedAt = &at
+		r.apiKeys[id] = k
+	}
+	return nil
+}
+
+func (r *MemoryTokenRepository) TouchAPIKey(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k, ok := r.apiKeys[id]; ok {
+		k.LastUsedAt = &at
+		r.apiKeys[id] = k
+	}
+	return nil
+}
+
+// Returns the enrollment with the given ID; caller holds the lock
+func (r *MemoryTokenRepository) twoFactorByID(id uint) (models.TwoFactor, bool) {
+	for _, tf := range r.twoFactor {
+		if tf.ID == id {
+			return tf, true
+		}
+	}
+	return models.TwoFactor{}, false
+}
+
+func (r *MemoryTokenRepository) GetTwoFactor(ctx context.Context, userID uint) (models.TwoFactor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tf, ok := r.twoFactor[userID]
+	if !ok {
+		return tf, twoFactorNotFound(userID)
+	}
+	tf.RecoveryCodes = nil
+	return tf, nil
+}
+
+func (r *MemoryTokenRepository) SaveTwoFactor(ctx context.Context, tf *models.TwoFactor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextTFID++
+	tf.ID = r.nextTFID
+	r.twoFactor[tf.UserID] = *tf
+	return nil
+}
+
+func (r *MemoryTokenRepository) ConfirmTwoFactor(ctx context.Context, id uint, step int64, at time.Time, codes []models.RecoveryCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tf, ok := r.twoFactorByID(id)
+	if !ok {
+		return nil
+	}
+	tf.ConfirmedAt = &at
+	tf.LastUsedStep = step
+	tf.RecoveryCodes = append([]models.RecoveryCode(nil), codes...)
+	for i := range tf.RecoveryCodes {
+		tf.RecoveryCodes[i].TwoFactorID = id
+	}
+	r.twoFactor[tf.UserID] = tf
+	return nil
+}
+
+func (r *MemoryTokenRepository) UseTOTPStep(ctx context.Context, id uint, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tf, ok := r.twoFactorByID(id)
+	if !ok || step <= tf.LastUsedStep {
+		return false, nil
+	}
+	tf.LastUsedStep = step
+	r.twoFactor[tf.UserID] = tf
+	return true, nil
+}
+
+func (r *MemoryTokenRepository) UseRecoveryCode(ctx context.Context, twoFactorID uint, hash string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tf, ok := r.twoFactorByID(twoFactorID)
+	if !ok {
+		return false, nil
+	}
+	for i, code := range tf.RecoveryCodes {
+		if code.Hash == hash && code.UsedAt == nil {
+			tf.RecoveryCodes[i].UsedAt = &at
+			r.twoFactor[tf.UserID] = tf
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *MemoryTokenRepository) DeleteTwoFactor(ctx context.Context, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.twoFactor[userID]; !ok {
+		return twoFactorNotFound(userID)
+	}
+	delete(r.twoFactor, userID)
+	return nil
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"assignment2/servertiming"
+)
+
+// pool is the *sql.DB of the SQL repositories. It marks where each call
+// starts, so that the time the call waits for a connection is measured.
+type pool struct {
+	*sql.DB
+}
+
+func (p pool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	servertiming.Begin(ctx)
+	return p.DB.ExecContext(ctx, query, args...)
+}
+
+func (p pool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	servertiming.Begin(ctx)
+	return p.DB.QueryContext(ctx, query, args...)
+}
+
+func (p pool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	servertiming.Begin(ctx)
+	return p.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (p pool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	servertiming.Begin(ctx)
+	return p.DB.BeginTx(ctx, opts)
+}
@@ -0,0 +1,167 @@
+// Package repository defines UserRepository and TokenRepository, the storage
+// interfaces used by the HTTP handlers and package auth, with database/sql,
+// GORM and in-memory implementations.
+//
+// Every operation takes the context of the request it serves, which carries
+// the request ID and logger of package logging; the database/sql and GORM
+// implementations pass it on with each query.
+package repository
+
+import (
+	"context"
+	"time"
+
+	"assignment2/apperr"
+	"assignment2/models"
+	"assignment2/querybuilder"
+)
+
+// ErrNotFound matches, with errors.Is, the error returned when the requested
+// user or profile does not exist
+var ErrNotFound = apperr.ErrNotFound
+
+func userNotFound(id uint) error {
+	return apperr.NotFound("user %d not found", id)
+}
+
+func profileNotFound(userID uint) error {
+	return apperr.NotFound("profile of user %d not found", userID)
+}
+
+func userNameNotFound(name string) error {
+	return apperr.NotFound("user %q not found", name)
+}
+
+func refreshTokenNotFound() error {
+	return apperr.NotFound("refresh token not found")
+}
+
+func apiKeyNotFound(id uint) error {
+	return apperr.NotFound("API key %d not found", id)
+}
+
+func apiKeyHashNotFound() error {
+	return apperr.NotFound("API key not found")
+}
+
+func twoFactorNotFound(userID uint) error {
+	return apperr.NotFound("two-factor authentication is not set up for user %d", userID)
+}
+
+// UserUpdate holds the user fields to change; nil fields are left unchanged
+type UserUpdate struct {
+	Name *string `json:"name" validate:"required,max=100"`
+	Age  *int    `json:"age" validate:"min=0,max=150"`
+}
+
+// UserReplacement holds the user fields a full update replaces. The role,
+// profile and ID have endpoints of their own and are rejected here.
+type UserReplacement struct {
+	Name string `json:"name" validate:"required,max=100"`
+	Age  int    `json:"age" validate:"min=0,max=150"`
+}
+
+// Update returns the update that sets every field of r
+func (r UserReplacement) Update() UserUpdate {
+	return UserUpdate{Name: &r.Name, Age: &r.Age}
+}
+
+// UserRepository stores users and their profiles
+type UserRepository interface {
+	// Create inserts user, and its profile if it has one, filling in the new
+	// IDs. An empty role is stored as models.RoleUser.
+	Create(ctx context.Context, user *models.User) error
+	// Get returns the user with its profile
+	Get(ctx context.Context, id uint) (models.User, error)
+	// GetByName returns the user with the given name, including its password
+	// hash. Profiles are not loaded.
+	GetByName(ctx context.Context, name string) (models.User, error)
+	// List returns at most limit users matching params, after skipping offset.
+	// Profiles are not loaded.
+	List(ctx context.Context, params querybuilder.ListParams, limit, offset int) ([]models.User, error)
+	// Count returns the number of users matching the filters in params
+	Count(ctx context.Context, params querybuilder.ListParams) (int64, error)
+	// Update applies the non-nil fields of update and returns the updated user
+	Update(ctx context.Context, id uint, update UserUpdate) (models.User, error)
+	// Delete removes the user and its profile
+	Delete(ctx context.Context, id uint) error
+	// SetRole changes the role of the user and returns the updated user
+	SetRole(ctx context.Context, id uint, role string) (models.User, error)
+
+	// GetProfile returns the profile of the given user
+	GetProfile(ctx context.Context, userID uint) (models.Profile, error)
+	// SaveProfile creates or replaces the profile of the given user
+	SaveProfile(ctx context.Context, userID uint, profile models.Profile) (models.Profile, error)
+}
+
+// TokenRepository stores the refresh tokens, revoked access tokens, API keys
+// and two-factor enrollments of package auth
+type TokenRepository interface {
+	// CreateRefreshToken inserts token, filling in its ID
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	// GetRefreshToken returns the refresh token with the given hash
+	GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error)
+	// RevokeRefreshToken revokes the token unless it already was revoked,
+	// reporting whether this call revoked it. Only one of several concurrent
+	// calls for the same token succeeds.
+	RevokeRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error)
+	// RevokeUserRefreshTokens revokes every refresh token of the user
+	RevokeUserRefreshTokens(ctx context.Context, userID uint, at time.Time) error
+
+	// RevokeAccessToken records that an access token must no longer be
+	// accepted, and forgets the revoked tokens that have expired since
+	RevokeAccessToken(ctx context.Context, token models.RevokedToken) error
+	// IsAccessTokenRevoked reports whether the access token with the given ID was revoked
+	IsAccessTokenRevoked(ctx context.Context, id string) (bool, error)
+
+	// CreateAPIKey inserts key, filling in its ID
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// GetAPIKey returns the API key with the given hash
+	GetAPIKey(ctx context.Context, hash string) (models.APIKey, error)
+	// ListAPIKeys returns the API keys of the user, newest first
+	ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error)
+	// RevokeAPIKey revokes the user's API key with the given ID
+	RevokeAPIKey(ctx context.Context, userID, id uint, at time.Time) error
+	// TouchAPIKey records that the API key was used at the given time
+	TouchAPIKey(ctx context.Context, id uint, at time.Time) error
+
+	// GetTwoFactor returns the two-factor enrollment of the user, without
+	// its recovery codes
+	GetTwoFactor(ctx context.Context, userID uint) (models.TwoFactor, error)
+	// SaveTwoFactor stores a new, unconfirmed enrollment, filling in its ID.
+	// Any previous enrollment of the user is removed with its recovery codes.
+	SaveTwoFactor(ctx context.Context, tf *models.TwoFactor) error
+	// ConfirmTwoFactor enables the enrollment, recording the time step of the
+	// code that confirmed it, and replaces its recovery codes with codes
+	ConfirmTwoFactor(ctx context.Context, id uint, step int64, at time.Time, codes []models.RecoveryCode) error
+	// UseTOTPStep records step as the last used time step, reporting false if
+	// it is not later than the last one, i.e. the code was used before
+	UseTOTPStep(ctx context.Context, id uint, step int64) (bool, error)
+	// UseRecoveryCode marks the enrollment's unused recovery code with the
+	// given hash as used, reporting false if there is no such code
+	UseRecoveryCode(ctx context.Context, twoFactorID uint, hash string, at time.Time) (bool, error)
+	// DeleteTwoFactor removes the user's enrollment and its recovery codes
+	DeleteTwoFactor(ctx context.Context, userID uint) error
+}
+
+// SortValues returns the values of the user's sort columns, in the order
+// given by params.SortFields, for building pagination cursors.
+func SortValues(params querybuilder.ListParams, user models.User) []interface{} {
+	fields := params.SortFields()
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		values[i] = columnValue(user, f.Column)
+	}
+	return values
+}
+
+func columnValue(user models.User, column string) interface{} {
+	switch column {
+	case "name":
+		return user.Name
+	case "age":
+		return user.Age
+	default:
+		return user.ID
+	}
+}
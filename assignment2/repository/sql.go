@@ -0,0 +1,459 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"assignment2/dberr"
+	"assignment2/models"
+	"assignment2/querybuilder"
+	"assignment2/txn"
+)
+
+// SQLRepository implements UserRepository with plain database/sql queries
+type SQLRepository struct {
+	db pool
+}
+
+// NewSQLRepository returns a repository using db
+func NewSQLRepository(db *sql.DB) *SQLRepository {
+	return &SQLRepository{db: pool{db}}
+}
+
+func (r *SQLRepository) Create(ctx context.Context, user *models.User) error {
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	err := txn.WithTx(ctx, r.db, nil, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "INSERT INTO users (name, age, role, password_hash) VALUES (?, ?, ?, ?)",
+			user.Name, user.Age, user.Role, user.PasswordHash)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		user.ID = uint(id)
+
+		if !user.Profile.IsZero() {
+			user.Profile.UserID = user.ID
+			result, err = tx.ExecContext(ctx, "INSERT INTO profiles (user_id, bio, profile_picture_url) VALUES (?, ?, ?)",
+				user.ID, user.Profile.Bio, user.Profile.ProfilePictureURL)
+			if err != nil {
+				return err
+			}
+			id, err = result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			user.Profile.ID = uint(id)
+		}
+		return nil
+	})
+	return dberr.Classify(err)
+}
+
+func (r *SQLRepository) Get(ctx context.Context, id uint) (models.User, error) {
+	var user models.User
+	var profileID sql.NullInt64
+	var bio, pictureURL sql.NullString
+	err := r.db.QueryRowContext(ctx, `SELECT u.id, u.name, u.age, u.role, p.id, p.bio, p.profile_picture_url
+		FROM users u LEFT JOIN profiles p ON p.user_id = u.id WHERE u.id = ?`, id).
+		Scan(&user.ID, &user.Name, &user.Age, &user.Role, &profileID, &bio, &pictureURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, userNotFound(id)
+	}
+	if err != nil {
+		return user, dberr.Classify(err)
+	}
+	if profileID.Valid {
+		user.Profile = models.Profile{
+			ID:                uint(profileID.Int64),
+			UserID:            user.ID,
+			Bio:               bio.String,
+			ProfilePictureURL: pictureURL.String,
+		}
+	}
+	return user, nil
+}
+
+func (r *SQLRepository) GetByName(ctx context.Context, name string) (models.User, error) {
+	var user models.User
+	err := r.db.QueryRowContext(ctx, "SELECT id, name, age, role, password_hash FROM users WHERE name = ?", name).
+		Scan(&user.ID, &user.Name, &user.Age, &user.Role, &user.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, userNameNotFound(name)
+	}
+	return user, dberr.Classify(err)
+}
+
+func (r *SQLRepository) List(ctx context.Context, params querybuilder.ListParams, limit, offset int) ([]models.User, error) {
+	query, args := params.Build("SELECT id, name, age, role FROM users")
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dberr.Classify(err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.Role); err != nil {
+			return nil, dberr.Classify(err)
+		}
+		users = append(users, user)
+	}
+	return users, dberr.Classify(rows.Err())
+}
+
+func (r *SQLRepository) Count(ctx context.Context, params querybuilder.ListParams) (int64, error) {
+	var total int64
+	query, args := params.BuildCount("SELECT COUNT(*) FROM users")
+	err := r.db.QueryRowContext(ctx, query, args...).Scan(&total)
+	return total, dberr.Classify(err)
+}
+
+func (r *SQLRepository) Update(ctx context.Context, id uint, update UserUpdate) (models.User, error) {
+	// MySQL reports zero affected rows when nothing changed, so load the row first
+	user, err := r.Get(ctx, id)
+	if err != nil {
+		return user, err
+	}
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.Age != nil {
+		user.Age = *update.Age
+	}
+
+	_, err = r.db.ExecContext(ctx, "UPDATE users SET name = ?, age = ? WHERE id = ?", user.Name, user.Age, id)
+	return user, dberr.Classify(err)
+}
+
+func (r *SQLRepository) Delete(ctx context.Context, id uint) error {
+	err := txn.WithTx(ctx, r.db, nil, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM profiles WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		if err := deleteCredentials(ctx, tx, id); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return userNotFound(id)
+		}
+		return nil
+	})
+	return dberr.Classify(err)
+}
+
+// Deletes the user's refresh tokens, API keys and 2FA enrollment, so that
+// nothing issued to a deleted user can still be used
+func deleteCredentials(ctx context.Context, tx *sql.Tx, userID uint) error {
+	for _, query := range []string{
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM api_keys WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+	return deleteTwoFactor(ctx, tx, userID)
+}
+
+func (r *SQLRepository) SetRole(ctx context.Context, id uint, role string) (models.User, error) {
+	if _, err := r.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, id); err != nil {
+		return models.User{}, dberr.Classify(err)
+	}
+	// MySQL reports zero affected rows when the role did not change, so Get
+	// tells whether the user exists
+	return r.Get(ctx, id)
+}
+
+func (r *SQLRepository) GetProfile(ctx context.Context, userID uint) (models.Profile, error) {
+	profile := models.Profile{UserID: userID}
+	err := r.db.QueryRowContext(ctx, "SELECT id, bio, profile_picture_url FROM profiles WHERE user_id = ?", userID).
+		Scan(&profile.ID, &profile.Bio, &profile.ProfilePictureURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return profile, profileNotFound(userID)
+	}
+	return profile, dberr.Classify(err)
+}
+
+func (r *SQLRepository) SaveProfile(ctx context.Context, userID uint, profile models.Profile) (models.Profile, error) {
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists); err != nil {
+		return profile, dberr.Classify(err)
+	}
+	if !exists {
+		return profile, userNotFound(userID)
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO profiles (user_id, bio, profile_picture_url) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE bio = VALUES(bio), profile_picture_url = VALUES(profile_picture_url)`,
+		userID, profile.Bio, profile.ProfilePictureURL)
+	if err != nil {
+		return profile, dberr.Classify(err)
+	}
+	return r.GetProfile(ctx, userID)
+}
+
+// SQLTokenRepository implements TokenRepository with plain database/sql queries
+type SQLTokenRepository struct {
+	db pool
+}
+
+// NewSQLTokenRepository returns a token repository using db
+func NewSQLTokenRepository(db *sql.DB) *SQLTokenRepository {
+	return &SQLTokenRepository{db: pool{db}}
+}
+
+func (r *SQLTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO refresh_tokens (user_id, hash, expires_at) VALUES (?, ?, ?)",
+		token.UserID, token.Hash, token.ExpiresAt)
+	if err != nil {
+		return dberr.Classify(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return dberr.Classify(err)
+	}
+	token.ID = uint(id)
+	return nil
+}
+
+func (r *SQLTokenRepository) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	token := models.RefreshToken{Hash: hash}
+	var revokedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT id, user_id, expires_at, revoked_at FROM refresh_tokens WHERE hash = ?", hash).
+		Scan(&token.ID, &token.UserID, &token.ExpiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return token, refreshTokenNotFound()
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return token, dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) RevokeRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", at, id)
+	if err != nil {
+		return false, dberr.Classify(err)
+	}
+	n, err := result.RowsAffected()
+	return n == 1, dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID uint, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", at, userID)
+	return dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) RevokeAccessToken(ctx context.Context, token models.RevokedToken) error {
+	// Expired tokens are rejected anyway, so their rows are purged as new
+	// ones come in
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now()); err != nil {
+		return dberr.Classify(err)
+	}
+	// Revoking the same token twice is not an error
+	_, err := r.db.ExecContext(ctx, "INSERT IGNORE INTO revoked_tokens (id, expires_at) VALUES (?, ?)", token.ID, token.ExpiresAt)
+	return dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE id = ?)", id).Scan(&revoked)
+	return revoked, dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	result, err := r.db.ExecContext(ctx, `INSERT INTO api_keys (user_id, name, prefix, hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.UserID, key.Name, key.Prefix, key.Hash, key.Scopes, key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return dberr.Classify(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return dberr.Classify(err)
+	}
+	key.ID = uint(id)
+	return nil
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, hash, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+// Scans a row of apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (models.APIKey, error) {
+	var key models.APIKey
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.Scopes,
+		&key.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	key.ExpiresAt = nullTime(expiresAt)
+	key.LastUsedAt = nullTime(lastUsedAt)
+	key.RevokedAt = nullTime(revokedAt)
+	return key, err
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (r *SQLTokenRepository) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE hash = ?", hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, apiKeyHashNotFound()
+	}
+	return key, dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, dberr.Classify(err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, dberr.Classify(err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, dberr.Classify(rows.Err())
+}
+
+func (r *SQLTokenRepository) RevokeAPIKey(ctx context.Context, userID, id uint, at time.Time) error {
+	// COALESCE keeps the original time when the key is revoked twice
+	result, err := r.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ? AND user_id = ?",
+		at, id, userID)
+	if err != nil {
+		return dberr.Classify(err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return dberr.Classify(err)
+	}
+
+	// MySQL only counts changed rows, so check whether the key exists at all
+	var exists bool
+	err = r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM api_keys WHERE id = ? AND user_id = ?)", id, userID).Scan(&exists)
+	if err != nil {
+		return dberr.Classify(err)
+	}
+	if !exists {
+		return apiKeyNotFound(id)
+	}
+	return nil
+}
+
+func (r *SQLTokenRepository) TouchAPIKey(ctx context.Context, id uint, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", at, id)
+	return dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) GetTwoFactor(ctx context.Context, userID uint) (models.TwoFactor, error) {
+	tf := models.TwoFactor{UserID: userID}
+	var confirmedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT id, secret, confirmed_at, last_used_step FROM two_factors WHERE user_id = ?", userID).
+		Scan(&tf.ID, &tf.Secret, &confirmedAt, &tf.LastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tf, twoFactorNotFound(userID)
+	}
+	tf.ConfirmedAt = nullTime(confirmedAt)
+	return tf, dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) SaveTwoFactor(ctx context.Context, tf *models.TwoFactor) error {
+	err := txn.WithTx(ctx, r.db, nil, func(tx *sql.Tx) error {
+		if err := deleteTwoFactor(ctx, tx, tf.UserID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, "INSERT INTO two_factors (user_id, secret, confirmed_at, last_used_step) VALUES (?, ?, ?, ?)",
+			tf.UserID, tf.Secret, tf.ConfirmedAt, tf.LastUsedStep)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		tf.ID = uint(id)
+		return nil
+	})
+	return dberr.Classify(err)
+}
+
+// Deletes the user's enrollment and recovery codes
+func deleteTwoFactor(ctx context.Context, tx *sql.Tx, userID uint) error {
+	_, err := tx.ExecContext(ctx, `DELETE rc FROM recovery_codes rc
+		JOIN two_factors tf ON tf.id = rc.two_factor_id WHERE tf.user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM two_factors WHERE user_id = ?", userID)
+	return err
+}
+
+func (r *SQLTokenRepository) ConfirmTwoFactor(ctx context.Context, id uint, step int64, at time.Time, codes []models.RecoveryCode) error {
+	err := txn.WithTx(ctx, r.db, nil, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE two_factors SET confirmed_at = ?, last_used_step = ? WHERE id = ?", at, step, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE two_factor_id = ?", id); err != nil {
+			return err
+		}
+		for _, code := range codes {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (two_factor_id, hash) VALUES (?, ?)", id, code.Hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) UseTOTPStep(ctx context.Context, id uint, step int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE two_factors SET last_used_step = ? WHERE id = ? AND last_used_step < ?", step, id, step)
+	if err != nil {
+		return false, dberr.Classify(err)
+	}
+	n, err := result.RowsAffected()
+	return n == 1, dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) UseRecoveryCode(ctx context.Context, twoFactorID uint, hash string, at time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `UPDATE recovery_codes SET used_at = ?
+		WHERE two_factor_id = ? AND hash = ? AND used_at IS NULL LIMIT 1`, at, twoFactorID, hash)
+	if err != nil {
+		return false, dberr.Classify(err)
+	}
+	n, err := result.RowsAffected()
+	return n == 1, dberr.Classify(err)
+}
+
+func (r *SQLTokenRepository) DeleteTwoFactor(ctx context.Context, userID uint) error {
+	if _, err := r.GetTwoFactor(ctx, userID); err != nil {
+		return err
+	}
+	return dberr.Classify(txn.WithTx(ctx, r.db, nil, func(tx *sql.Tx) error {
+		return deleteTwoFactor(ctx, tx, userID)
+	}))
+}
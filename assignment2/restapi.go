@@ -1,165 +1,663 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+
+	"assignment2/apperr"
+	"assignment2/auth"
+	"assignment2/config"
+	"assignment2/deadline"
+	"assignment2/health"
+	"assignment2/loadshed"
+	"assignment2/logging"
+	"assignment2/metrics"
+	"assignment2/models"
+	"assignment2/pagination"
+	"assignment2/policy"
+	"assignment2/problem"
+	"assignment2/querybuilder"
+	"assignment2/ratelimit"
+	"assignment2/repository"
+	"assignment2/schema"
+	"assignment2/server"
+	"assignment2/servertiming"
+	"assignment2/slowquery"
+	"assignment2/tracing"
+	"assignment2/validation"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
-// User struct for GORM
-type User struct {
-	ID   uint   `json:"id" gorm:"primaryKey"`
-	Name string `json:"name" gorm:"not null;unique"`
-	Age  int    `json:"age" gorm:"not null"`
-}
-
 // DB variables
 var db *gorm.DB
 var sqlDB *sql.DB
 
-// Connect to the database using GORM
-func connectDatabase() {
-	dsn := "root:password@tcp(127.0.0.1:3306)/gocon?charset=utf8mb4&parseTime=True&loc=Local"
-	var err error
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// Signs list cursors; set cursor_secret so cursors survive restarts
+var cursorCodec *pagination.Codec
+
+// Connect to the database using GORM, logging slow statements
+func connectDatabase(cfg config.DatabaseConfig, slow *slowquery.Detector) {
+	connector, err := cfg.Connector()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Invalid database settings", err)
 	}
 
-	// Get the generic database object sql.DB to use it in raw queries
-	sqlDB, err = db.DB()
+	// Open the sql.DB used by raw queries, whose queries are traced,
+	// counted for Server-Timing and checked for slow statements, and run
+	// GORM on top of it so that its queries are too
+	sqlDB = tracing.OpenDB(servertiming.Connector(slow.Connector("gorm", connector)))
+	cfg.ApplyPool(sqlDB)
+
+	db, err = gorm.Open(mysql.New(mysql.Config{Conn: sqlDB}), &gorm.Config{})
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Failed to connect to the database", err)
+	}
+	if err := errors.Join(db.Use(tracing.GORMPlugin()), db.Use(servertiming.GORMPlugin())); err != nil {
+		logging.Fatal("Failed to set up the GORM plugins", err)
+	}
+}
+
+// Auto migrate the user, profile, token and API key models
+func migrate(timeout config.Duration) {
+	ctx, cancel := deadline.Context(context.Background(), timeout)
+	defer cancel()
+	if err := schema.Migrate(ctx, db); err != nil {
+		logging.Fatal("Failed to migrate the database", err)
+	}
+	slog.Info("User, profile, token and API key tables migrated")
+}
+
+// userHandlers serves the user routes on top of a UserRepository, so the
+// same handlers back both the GORM and direct SQL routes
+type userHandlers struct {
+	repo    repository.UserRepository
+	require func(scope string) gin.HandlerFunc // authenticates and checks the scope
+	limit   gin.HandlerFunc                    // rate limits the client, also before it is authenticated
+}
+
+// Returns the repository as seen by the caller, enforcing its role and ownership
+func (h userHandlers) repoFor(c *gin.Context) repository.UserRepository {
+	caller, _ := auth.FromContext(c.Request.Context())
+	return policy.NewGuard(h.repo, caller)
+}
+
+// Writes err as an RFC 7807 problem response and stops the handler chain
+func writeError(c *gin.Context, err error) {
+	problem.Write(c.Writer, c.Request, err)
+	c.Abort()
+}
+
+// Parses the :id route parameter, writing a 400 response if it is not a valid ID
+func userIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		writeError(c, apperr.BadRequest("Invalid user ID", err))
+		return 0, false
 	}
+	return uint(id), true
 }
 
-// Auto migrate the User model
-func migrate() {
-	err := db.AutoMigrate(&User{})
+// Parses the filter, sort and pagination parameters shared by the list handlers
+func listRequest(c *gin.Context) (querybuilder.ListParams, pagination.Request, error) {
+	q := c.Request.URL.Query()
+	params, err := querybuilder.Parse(q)
+	if err != nil {
+		return params, pagination.Request{}, err
+	}
+	pg, err := cursorCodec.Parse(q, params.SortKey())
 	if err != nil {
-		log.Fatal(err)
+		return params, pg, err
 	}
-	fmt.Println("User table migrated.")
+	if pg.Cursor != nil {
+		params.Keyset = &querybuilder.Keyset{Values: pg.Cursor.Values, Backward: pg.Cursor.Backward}
+	}
+	return params, pg, nil
 }
 
-// Handler to fetch all users (using GORM)
-func getUsersGORM(c *gin.Context) {
-	var users []User
-	result := db.Find(&users)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+// Handler to fetch users with filtering and pagination
+func (h userHandlers) listUsers(c *gin.Context) {
+	params, pg, err := listRequest(c)
+	if err != nil {
+		writeError(c, apperr.BadRequest("Invalid query", err))
+		return
+	}
+
+	repo := h.repoFor(c)
+	users, err := repo.List(c.Request.Context(), params, pg.Limit+1, pg.Offset())
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to retrieve users", err))
 		return
 	}
-	c.JSON(http.StatusOK, users)
+	total, err := repo.Count(c.Request.Context(), params)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to count users", err))
+		return
+	}
+
+	page := pagination.Paginate(cursorCodec, pg, users, func(user models.User) []interface{} {
+		return repository.SortValues(params, user)
+	})
+	pagination.SetHeaders(c.Writer.Header(), c.Request.URL, pg, page, total)
+	if pagination.WantsEnvelope(c.Request.URL.Query()) {
+		c.JSON(http.StatusOK, page.Envelope(pg, total))
+		return
+	}
+	c.JSON(http.StatusOK, page.Items)
 }
 
-// Handler to create a user (using GORM)
-func createUserGORM(c *gin.Context) {
-	var user User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// Handler to fetch a single user with its profile
+func (h userHandlers) getUser(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
 		return
 	}
 
-	result := db.Create(&user)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	user, err := h.repoFor(c).Get(c.Request.Context(), id)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to retrieve user", err))
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// Handler to create a user
+func (h userHandlers) createUser(c *gin.Context) {
+	var user models.User
+	if err := validation.DecodeJSON(c.Request.Body, &user); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	if err := h.repoFor(c).Create(c.Request.Context(), &user); err != nil {
+		writeError(c, apperr.Wrap("Failed to create user", err))
 		return
 	}
 	c.JSON(http.StatusCreated, user)
 }
 
-// Handler to update a user (using GORM)
-func updateUserGORM(c *gin.Context) {
-	id := c.Param("id")
-	var user User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// Handler to replace a user's name and age
+func (h userHandlers) updateUser(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	var input repository.UserReplacement
+	if err := validation.DecodeJSON(c.Request.Body, &input); err != nil {
+		writeError(c, err)
 		return
 	}
 
-	result := db.Model(&User{}).Where("id = ?", id).Updates(user)
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	user, err := h.repoFor(c).Update(c.Request.Context(), id, input.Update())
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to update user", err))
 		return
 	}
 	c.JSON(http.StatusOK, user)
 }
 
-// Handler to delete a user (using GORM)
-func deleteUserGORM(c *gin.Context) {
-	id := c.Param("id")
-	result := db.Delete(&User{}, id)
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+// Handler to update only the supplied fields of a user
+func (h userHandlers) patchUser(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	var update repository.UserUpdate
+	if err := validation.DecodeJSON(c.Request.Body, &update); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	user, err := h.repoFor(c).Update(c.Request.Context(), id, update)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to update user", err))
 		return
 	}
-	c.JSON(http.StatusNoContent, nil)
+	c.JSON(http.StatusOK, user)
 }
 
-// Handler to fetch all users (using direct SQL)
-func getUsersSQL(c *gin.Context) {
-	rows, err := sqlDB.Query("SELECT id, name, age FROM users")
+// Handler to delete a user and its profile
+func (h userHandlers) deleteUser(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repoFor(c).Delete(c.Request.Context(), id); err != nil {
+		writeError(c, apperr.Wrap("Failed to delete user", err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Handler to fetch a user's profile
+func (h userHandlers) getProfile(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	profile, err := h.repoFor(c).GetProfile(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, apperr.Wrap("Failed to retrieve profile", err))
 		return
 	}
-	defer rows.Close()
+	c.JSON(http.StatusOK, profile)
+}
 
-	var users []User
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.Name, &user.Age); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		users = append(users, user)
+// Handler to create or replace a user's profile
+func (h userHandlers) saveProfile(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
 	}
-	c.JSON(http.StatusOK, users)
+	var input models.Profile
+	if err := validation.DecodeJSON(c.Request.Body, &input); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	profile, err := h.repoFor(c).SaveProfile(c.Request.Context(), id, input)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to save profile", err))
+		return
+	}
+	c.JSON(http.StatusOK, profile)
 }
 
-// Handler to create a user (using direct SQL)
-func createUserSQL(c *gin.Context) {
-	var user User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// Handler to give a user the user or admin role; only admins may do so
+func (h userHandlers) assignRole(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	var input policy.RoleAssignment
+	if err := validation.DecodeJSON(c.Request.Body, &input); err != nil {
+		writeError(c, err)
 		return
 	}
 
-	_, err := sqlDB.Exec("INSERT INTO users (name, age) VALUES (?, ?)", user.Name, user.Age)
+	user, err := h.repoFor(c).SetRole(c.Request.Context(), id, input.Role)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, apperr.Wrap("Failed to assign role", err))
 		return
 	}
-	c.JSON(http.StatusCreated, user)
+	c.JSON(http.StatusOK, user)
+}
+
+// Registers the user routes under prefix, e.g. "/gorm" or "/sql"
+func (h userHandlers) register(router gin.IRouter, prefix string) {
+	readUsers, writeUsers := h.require(auth.ScopeUsersRead), h.require(auth.ScopeUsersWrite)
+	router.GET(prefix+"/users", h.limit, readUsers, h.limit, h.listUsers)
+	router.POST(prefix+"/user", h.limit, writeUsers, h.limit, h.createUser)
+	router.GET(prefix+"/user/:id", h.limit, readUsers, h.limit, h.getUser)
+	router.PUT(prefix+"/user/:id", h.limit, writeUsers, h.limit, h.updateUser)
+	router.PATCH(prefix+"/user/:id", h.limit, writeUsers, h.limit, h.patchUser)
+	router.DELETE(prefix+"/user/:id", h.limit, writeUsers, h.limit, h.deleteUser)
+	router.GET(prefix+"/user/:id/profile", h.limit, h.require(auth.ScopeProfilesRead), h.limit, h.getProfile)
+	router.PUT(prefix+"/user/:id/profile", h.limit, h.require(auth.ScopeProfilesWrite), h.limit, h.saveProfile)
+}
+
+// Registers the administration routes
+func (h userHandlers) registerAdmin(router gin.IRouter) {
+	router.PUT("/admin/user/:id/role", h.limit, h.require(auth.ScopeAdmin), h.limit, h.assignRole)
+}
+
+// authHandlers serves the account routes
+type authHandlers struct {
+	svc   *auth.Service
+	users repository.UserRepository // checked by the admin routes
+	limit gin.HandlerFunc
+}
+
+// Handler to create a user with a password and log it in
+func (h authHandlers) registerUser(c *gin.Context) {
+	var reg auth.Registration
+	if err := validation.DecodeJSON(c.Request.Body, &reg); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	session, err := h.svc.Register(c.Request.Context(), reg)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to register", err))
+		return
+	}
+	c.JSON(http.StatusCreated, session)
+}
+
+// Handler to exchange a name and password for a session
+func (h authHandlers) login(c *gin.Context) {
+	var creds auth.Credentials
+	if err := validation.DecodeJSON(c.Request.Body, &creds); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	session, err := h.svc.Login(c.Request.Context(), creds)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to log in", err))
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// Handler to exchange a refresh token for a new session
+func (h authHandlers) refresh(c *gin.Context) {
+	var req auth.RefreshRequest
+	if err := validation.DecodeJSON(c.Request.Body, &req); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	session, err := h.svc.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to refresh session", err))
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// Handler to revoke the caller's access token and refresh token
+func (h authHandlers) logout(c *gin.Context) {
+	var req auth.RefreshRequest
+	if err := validation.DecodeJSON(c.Request.Body, &req); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	id, _ := auth.FromContext(c.Request.Context())
+	if err := h.svc.Logout(c.Request.Context(), id, req.RefreshToken); err != nil {
+		writeError(c, apperr.Wrap("Failed to log out", err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Handler to create an API key for the caller
+func (h authHandlers) createAPIKey(c *gin.Context) {
+	var req auth.APIKeyRequest
+	if err := validation.DecodeJSON(c.Request.Body, &req); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	id, _ := auth.FromContext(c.Request.Context())
+	key, err := h.svc.CreateAPIKey(c.Request.Context(), id, req)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to create API key", err))
+		return
+	}
+	c.JSON(http.StatusCreated, key)
+}
+
+// Handler to list the caller's API keys
+func (h authHandlers) listAPIKeys(c *gin.Context) {
+	id, _ := auth.FromContext(c.Request.Context())
+	keys, err := h.svc.ListAPIKeys(c.Request.Context(), id)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to list API keys", err))
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// Handler to revoke one of the caller's API keys
+func (h authHandlers) revokeAPIKey(c *gin.Context) {
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || keyID == 0 {
+		writeError(c, apperr.BadRequest("Invalid API key ID", err))
+		return
+	}
+
+	id, _ := auth.FromContext(c.Request.Context())
+	if err := h.svc.RevokeAPIKey(c.Request.Context(), id, uint(keyID)); err != nil {
+		writeError(c, apperr.Wrap("Failed to revoke API key", err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Handler to start enrolling the caller in two-factor authentication
+func (h authHandlers) enrollTwoFactor(c *gin.Context) {
+	id, _ := auth.FromContext(c.Request.Context())
+	enrollment, err := h.svc.EnrollTwoFactor(c.Request.Context(), id)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to enroll in two-factor authentication", err))
+		return
+	}
+	c.JSON(http.StatusCreated, enrollment)
+}
+
+// Handler to render the caller's unconfirmed enrollment as a QR code
+func (h authHandlers) twoFactorQRCode(c *gin.Context) {
+	id, _ := auth.FromContext(c.Request.Context())
+	png, err := h.svc.TwoFactorQRCode(c.Request.Context(), id)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to render the QR code", err))
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// Handler to enable two-factor authentication with a code from the authenticator
+func (h authHandlers) confirmTwoFactor(c *gin.Context) {
+	var req auth.OneTimeCode
+	if err := validation.DecodeJSON(c.Request.Body, &req); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	id, _ := auth.FromContext(c.Request.Context())
+	codes, err := h.svc.ConfirmTwoFactor(c.Request.Context(), id, req.Code)
+	if err != nil {
+		writeError(c, apperr.Wrap("Failed to confirm two-factor authentication", err))
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, codes)
+}
+
+// Handler for admins to remove a user's two-factor enrollment
+func (h authHandlers) resetTwoFactor(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	caller, _ := auth.FromContext(c.Request.Context())
+	if err := policy.NewGuard(h.users, caller).Authorize(c.Request.Context(), policy.ResetTwoFactor, id); err != nil {
+		writeError(c, err)
+		return
+	}
+	if err := h.svc.ResetTwoFactor(c.Request.Context(), id); err != nil {
+		writeError(c, apperr.Wrap("Failed to reset two-factor authentication", err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// logHandlers lets admins read and change the log level at runtime
+type logHandlers struct {
+	level *slog.LevelVar
+	users repository.UserRepository // checked for the caller's role
+	limit gin.HandlerFunc
+}
+
+// Aborts unless the caller may manage logging
+func (h logHandlers) authorize(c *gin.Context) {
+	caller, _ := auth.FromContext(c.Request.Context())
+	if err := policy.NewGuard(h.users, caller).Authorize(c.Request.Context(), policy.SetLogLevel, 0); err != nil {
+		writeError(c, err)
+	}
+}
+
+// Handler to read the log level
+func (h logHandlers) getLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, logging.CurrentLevel(h.level))
+}
+
+// Handler to change the log level until the server restarts
+func (h logHandlers) setLevel(c *gin.Context) {
+	var input logging.LevelSetting
+	if err := validation.DecodeJSON(c.Request.Body, &input); err != nil {
+		writeError(c, err)
+		return
+	}
+	level, err := logging.ParseLevel(input.Level)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	h.level.Set(level)
+	logging.FromContext(c.Request.Context()).Info("Log level changed", "level", input.Level)
+	c.JSON(http.StatusOK, logging.CurrentLevel(h.level))
+}
+
+// Registers the logging routes under /admin
+func (h logHandlers) register(router gin.IRouter, require gin.HandlerFunc) {
+	admin := router.Group("/admin", h.limit, require, h.limit, h.authorize)
+	admin.GET("/log-level", h.getLevel)
+	admin.PUT("/log-level", h.setLevel)
+}
+
+// slowQueryHandlers lets admins read the statistics of the slow-query log
+type slowQueryHandlers struct {
+	slow  *slowquery.Detector
+	users repository.UserRepository // checked for the caller's role
+	limit gin.HandlerFunc
+}
+
+// Handler to read the statistics of each statement shape, the one with the
+// most time in total first
+func (h slowQueryHandlers) getStats(c *gin.Context) {
+	caller, _ := auth.FromContext(c.Request.Context())
+	if err := policy.NewGuard(h.users, caller).Authorize(c.Request.Context(), policy.ViewSlowQueries, 0); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, h.slow.Stats())
+}
+
+// Registers the slow-query route under /admin
+func (h slowQueryHandlers) register(router gin.IRouter, require gin.HandlerFunc) {
+	router.GET("/admin/slow-queries", h.limit, require, h.limit, h.getStats)
+}
+
+// Registers the account routes under /auth
+func (h authHandlers) register(router gin.IRouter) {
+	// Anonymous requests are limited by IP address
+	router.POST("/auth/register", h.limit, h.registerUser)
+	router.POST("/auth/login", h.limit, h.login)
+	router.POST("/auth/refresh", h.limit, h.refresh)
+
+	authenticated := router.Group("/auth", h.limit, h.svc.GinMiddleware(), h.limit)
+	authenticated.POST("/logout", h.logout)
+	authenticated.POST("/api-keys", h.createAPIKey)
+	authenticated.GET("/api-keys", h.listAPIKeys)
+	authenticated.DELETE("/api-keys/:id", h.revokeAPIKey)
+	authenticated.POST("/2fa/enroll", h.enrollTwoFactor)
+	authenticated.GET("/2fa/qr.png", h.twoFactorQRCode)
+	authenticated.POST("/2fa/confirm", h.confirmTwoFactor)
+
+	router.DELETE("/admin/user/:id/2fa", h.limit, h.svc.GinRequire(auth.ScopeAdmin), h.limit, h.resetTwoFactor)
 }
 
 func main() {
+	cfg := config.MustLoad()
+	logger, level := logging.New(cfg.Log, os.Stderr)
+	slog.SetDefault(logger)
+	tracer, err := tracing.Setup(cfg.Tracing, "restapi")
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", err)
+	}
+	cursorCodec = pagination.NewCodec([]byte(cfg.CursorSecret.Value()))
+
 	// Connect to the database
-	connectDatabase()
-	fmt.Println("Connected to the database.")
+	slow := slowquery.New(cfg.SlowQuery)
+	connectDatabase(cfg.Database, slow)
+	slog.Info("Connected to the database")
 
-	// Migrate the User model
-	migrate()
+	// Migrate the models
+	migrate(cfg.Database.Timeouts.Migration)
 
-	// Set up Gin router
-	router := gin.Default()
+	// Export request, repository and pool metrics for Prometheus. The /sql
+	// routes use the GORM pool, so there is only one pool to report.
+	stats := metrics.New()
+	stats.RegisterDB("gorm", sqlDB)
 
-	// Routes for GORM
-	router.GET("/gorm/users", getUsersGORM)
-	router.POST("/gorm/user", createUserGORM)
-	router.PUT("/gorm/user/:id", updateUserGORM)
-	router.DELETE("/gorm/user/:id", deleteUserGORM)
+	// Repository operations run under their request's context and the
+	// deadline of their kind
+	timeouts := cfg.Database.Timeouts
+	gormRepo := stats.Users(deadline.Users(repository.NewGORMRepository(db), timeouts), "gorm")
+	sqlRepo := stats.Users(deadline.Users(repository.NewSQLRepository(sqlDB), timeouts), "sql")
 
-	// Routes for direct SQL
-	router.GET("/sql/users", getUsersSQL)
-	router.POST("/sql/user", createUserSQL)
+	// Set up Gin router; every request gets a span, an ID and an access log
+	// line, and is counted
+	router := gin.New()
+	router.Use(tracing.GinMiddleware(), logging.GinMiddleware(logger), stats.GinMiddleware(), problem.GinRecover())
 
-	// Start the server
-	router.Run(":8080")
+	// Shed load before it queues for database connections; the health
+	// checks and the metrics, which include the limiter's own, are always
+	// served. Gin only runs middleware on the routes registered after it.
+	shedder := loadshed.New(cfg.Concurrency, "/metrics", "/healthz", "/readyz")
+	router.Use(shedder.GinMiddleware())
+	stats.RegisterLimiter(shedder)
+	router.GET("/metrics", gin.WrapH(stats.Handler()))
+
+	// Liveness and readiness for the orchestrator; the pool must answer and
+	// the schema must be migrated
+	checker := health.New(cfg.Health)
+	checker.Add("gorm", health.Ping(sqlDB))
+	checker.Add("schema", func(ctx context.Context) error { return schema.Check(ctx, sqlDB) })
+	router.GET("/healthz", gin.WrapF(checker.Live))
+	router.GET("/readyz", gin.WrapF(checker.Ready))
+
+	// Accounts and tokens are stored through GORM
+	authService := auth.NewService(gormRepo, stats.Tokens(deadline.Tokens(repository.NewGORMTokenRepository(db), timeouts), "gorm"), cfg.Auth)
+
+	// Break down the database and encoding time of the API routes in a
+	// Server-Timing header, on every response if configured and otherwise
+	// for admins sending X-Debug-Timing
+	timing := servertiming.New(cfg.ServerTiming, func(r *http.Request) bool {
+		caller, err := authService.Identify(r)
+		return err == nil && policy.NewGuard(gormRepo, caller).Authorize(r.Context(), policy.ViewServerTiming, 0) == nil
+	})
+	router.Use(timing.GinMiddleware())
+
+	limiter, err := ratelimit.New(cfg.RateLimit, nil)
+	if err != nil {
+		logging.Fatal("Invalid rate limits", err)
+	}
+	limit := limiter.GinMiddleware()
+	authHandlers{svc: authService, users: gormRepo, limit: limit}.register(router)
+	logHandlers{level: level, users: gormRepo, limit: limit}.register(router, authService.GinRequire(auth.ScopeAdmin))
+	slowQueryHandlers{slow: slow, users: gormRepo, limit: limit}.register(router, authService.GinRequire(auth.ScopeAdmin))
+
+	// Routes for GORM and for direct SQL share the same handlers
+	gormUsers := userHandlers{repo: gormRepo, require: authService.GinRequire, limit: limit}
+	gormUsers.register(router, "/gorm")
+	gormUsers.registerAdmin(router)
+	userHandlers{repo: sqlRepo, require: authService.GinRequire, limit: limit}.register(router, "/sql")
+
+	if name := cfg.Auth.BootstrapAdmin; name != "" {
+		if err := policy.Bootstrap(context.Background(), gormRepo, name, cfg.Auth.BootstrapAdminPassword.Value()); err != nil {
+			slog.Warn("Could not create the bootstrap admin", "name", name, "err", err)
+		}
+	}
+
+	// Start the server; on SIGINT/SIGTERM it drains requests, closes the pool
+	// and the EXPLAIN connections and flushes the last spans
+	slog.Info("Server started", "addr", cfg.Server.Addr)
+	if err := server.Run(server.New(cfg.Server, router), cfg.Server, slow, sqlDB, tracer); err != nil {
+		logging.Fatal("Server failed", err)
+	}
 }
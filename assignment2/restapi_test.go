@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"assignment2/auth"
+	"assignment2/config"
+	"assignment2/policy"
+	"assignment2/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Run with: go test restapi.go restapi_test.go
+
+// testAPI serves the user routes under one prefix and the admin routes on
+// in-memory repositories, with an admin logged in
+type testAPI struct {
+	router *gin.Engine
+	svc    *auth.Service
+	admin  string // access token of the admin
+}
+
+func newTestAPI(t *testing.T, prefix string) *testAPI {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	tokens := repository.NewMemoryTokenRepository()
+	users := repository.NewMemoryRepository(tokens)
+	svc := auth.NewService(users, tokens, config.Defaults().Auth)
+
+	ctx := context.Background()
+	if err := policy.Bootstrap(ctx, users, "admin", "admin-password"); err != nil {
+		t.Fatalf("bootstrap admin: %v", err)
+	}
+	session, err := svc.Login(ctx, auth.Credentials{Name: "admin", Password: "admin-password"})
+	if err != nil {
+		t.Fatalf("log in admin: %v", err)
+	}
+
+	router := gin.New()
+	noLimit := func(*gin.Context) {}
+	handlers := userHandlers{repo: users, require: svc.GinRequire, limit: noLimit}
+	handlers.register(router, prefix)
+	handlers.registerAdmin(router)
+	return &testAPI{router: router, svc: svc, admin: session.AccessToken}
+}
+
+// Sends a request with token as the bearer token and returns the status
+func (a *testAPI) do(method, path, body, token string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// The GORM and SQL routes share their handlers, so the same requests must get
+// the same statuses whichever repository is behind them
+func TestUserRoutesStatuses(t *testing.T) {
+	// Steps run in order against one API; the admin has ID 1, the first user
+	// created has ID 2
+	steps := []struct {
+		name         string
+		method, path string
+		body         string
+		want         int
+	}{
+		{"get missing user", http.MethodGet, "/user/999", "", http.StatusNotFound},
+		{"delete missing user", http.MethodDelete, "/user/999", "", http.StatusNotFound},
+		{"create user", http.MethodPost, "/user", `{"name":"alice","age":30}`, http.StatusCreated},
+		{"create duplicate name", http.MethodPost, "/user", `{"name":"alice","age":31}`, http.StatusConflict},
+		{"create duplicate name in other case", http.MethodPost, "/user", `{"name":"ALICE","age":31}`, http.StatusConflict},
+		{"rename to taken name", http.MethodPatch, "/user/2", `{"name":"admin"}`, http.StatusConflict},
+		{"replace user", http.MethodPut, "/user/2", `{"name":"alice","age":32}`, http.StatusOK},
+		{"replace with role", http.MethodPut, "/user/2", `{"name":"alice","age":32,"role":"admin"}`, http.StatusUnprocessableEntity},
+		{"replace with profile", http.MethodPut, "/user/2", `{"name":"alice","age":32,"profile":{"bio":"hi"}}`, http.StatusUnprocessableEntity},
+		{"replace with ID", http.MethodPut, "/user/2", `{"id":3,"name":"alice","age":32}`, http.StatusUnprocessableEntity},
+		{"replace without name", http.MethodPut, "/user/2", `{"age":32}`, http.StatusUnprocessableEntity},
+		{"get user", http.MethodGet, "/user/2", "", http.StatusOK},
+		{"delete user", http.MethodDelete, "/user/2", "", http.StatusNoContent},
+		{"get deleted user", http.MethodGet, "/user/2", "", http.StatusNotFound},
+		{"delete deleted user", http.MethodDelete, "/user/2", "", http.StatusNotFound},
+		{"invalid ID", http.MethodGet, "/user/abc", "", http.StatusBadRequest},
+	}
+
+	for _, prefix := range []string{"/gorm", "/sql"} {
+		t.Run(strings.TrimPrefix(prefix, "/"), func(t *testing.T) {
+			api := newTestAPI(t, prefix)
+			for _, step := range steps {
+				if got := api.do(step.method, prefix+step.path, step.body, api.admin); got != step.want {
+					t.Errorf("%s: %s %s = %d, want %d", step.name, step.method, prefix+step.path, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+// An admin's API key carries the admin's role but not the admin scope, so it
+// cannot assign roles
+func TestAdminRoutesRejectAPIKeys(t *testing.T) {
+	api := newTestAPI(t, "/gorm")
+	ctx := context.Background()
+	if _, err := api.svc.Register(ctx, auth.Registration{Name: "bob", Age: 40, Password: "bob-password"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	admin, err := api.svc.Authenticate(ctx, api.admin)
+	if err != nil {
+		t.Fatalf("authenticate admin: %v", err)
+	}
+	if _, err := api.svc.CreateAPIKey(ctx, admin, auth.APIKeyRequest{Name: "ci", Scopes: []string{auth.ScopeAdmin}}); err == nil {
+		t.Errorf("created an API key with the %s scope", auth.ScopeAdmin)
+	}
+	key, err := api.svc.CreateAPIKey(ctx, admin, auth.APIKeyRequest{Name: "ci", Scopes: auth.APIKeyScopes})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	body := `{"role":"admin"}`
+	if got := api.do(http.MethodPut, "/admin/user/2/role", body, key.Key); got != http.StatusForbidden {
+		t.Errorf("assign role with an API key = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := api.do(http.MethodPut, "/admin/user/2/role", body, api.admin); got != http.StatusOK {
+		t.Errorf("assign role with an access token = %d, want %d", got, http.StatusOK)
+	}
+}
+
+// Deleting a user must end its sessions: its access token is rejected even
+// though it has not expired
+func TestDeletedUserTokenRejected(t *testing.T) {
+	for _, prefix := range []string{"/gorm", "/sql"} {
+		t.Run(strings.TrimPrefix(prefix, "/"), func(t *testing.T) {
+			api := newTestAPI(t, prefix)
+			session, err := api.svc.Register(context.Background(), auth.Registration{Name: "bob", Age: 40, Password: "bob-password"})
+			if err != nil {
+				t.Fatalf("register: %v", err)
+			}
+			path := prefix + "/user/2"
+			if got := api.do(http.MethodGet, path, "", session.AccessToken); got != http.StatusOK {
+				t.Fatalf("GET %s as bob = %d, want %d", path, got, http.StatusOK)
+			}
+			if got := api.do(http.MethodDelete, path, "", api.admin); got != http.StatusNoContent {
+				t.Fatalf("DELETE %s = %d, want %d", path, got, http.StatusNoContent)
+			}
+			if got := api.do(http.MethodGet, path, "", session.AccessToken); got != http.StatusUnauthorized {
+				t.Errorf("GET %s with a deleted user's token = %d, want %d", path, got, http.StatusUnauthorized)
+			}
+		})
+	}
+}
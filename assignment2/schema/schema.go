@@ -0,0 +1,70 @@
+// Package schema migrates the tables of the REST APIs and records the schema
+// version in schema_migrations, so that a server can tell whether the
+// database it uses was migrated for its models.
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"assignment2/apperr"
+	"assignment2/models"
+)
+
+// Version is the schema version of the models; increase it whenever a
+// migrated model changes
+const Version = 1
+
+// Migration records that the schema was migrated to a version
+type Migration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	AppliedAt time.Time
+}
+
+// TableName implements gorm's Tabler
+func (Migration) TableName() string {
+	return "schema_migrations"
+}
+
+// Models returns the models whose tables Migrate creates
+func Models() []interface{} {
+	return []interface{}{&models.User{}, &models.Profile{}, &models.RefreshToken{}, &models.RevokedToken{},
+		&models.APIKey{}, &models.TwoFactor{}, &models.RecoveryCode{}}
+}
+
+// Migrate creates or alters the tables of Models and records Version
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	db = db.WithContext(ctx)
+	if err := db.AutoMigrate(append(Models(), &Migration{})...); err != nil {
+		return err
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Migration{Version: Version, AppliedAt: time.Now()}).Error
+}
+
+// Current returns the highest version recorded in db, or 0 if there is none
+func Current(ctx context.Context, db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// Check returns an unavailable error unless db was migrated to at least
+// Version. Newer versions are accepted, since migrations only add tables
+// and columns, so that instances still running during a rolling deploy
+// stay ready.
+func Check(ctx context.Context, db *sql.DB) error {
+	version, err := Current(ctx, db)
+	if err != nil {
+		return err
+	}
+	if version < Version {
+		return apperr.Unavailable(fmt.Sprintf("schema version is %d, expected %d", version, Version), nil)
+	}
+	return nil
+}
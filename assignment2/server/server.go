@@ -0,0 +1,150 @@
+// Package server runs an http.Handler behind an explicitly configured
+// http.Server and shuts it down gracefully on SIGINT or SIGTERM.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"assignment2/apperr"
+	"assignment2/config"
+	"assignment2/problem"
+)
+
+// New returns a server for handler with the timeouts and size limits from cfg
+func New(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           LimitBody(cfg.MaxBodyBytes, handler),
+		ReadTimeout:       cfg.ReadTimeout.Duration,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration,
+		WriteTimeout:      cfg.WriteTimeout.Duration,
+		IdleTimeout:       cfg.IdleTimeout.Duration,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// LimitBody rejects request bodies larger than n bytes with a 413; reads past
+// the limit fail, which apperr.InvalidBody also reports as 413. n <= 0
+// disables the limit.
+func LimitBody(n int64, next http.Handler) http.Handler {
+	if n <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			problem.Write(w, r, apperr.TooLarge(n))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Run serves srv until SIGINT or SIGTERM, then stops accepting connections
+// and waits up to cfg.ShutdownTimeout for in-flight requests before forcing
+// the remaining connections closed. Closing a connection cancels the context
+// of its request, but not the handler itself, so Run then waits for the
+// handlers still running to return; a second signal stops the process
+// without waiting. The closers (e.g. database pools) are closed last, once
+// no handler can use them any more.
+func Run(srv *http.Server, cfg config.ServerConfig, closers ...io.Closer) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	handlers := newInflight()
+	srv.Handler = handlers.track(srv.Handler)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		// The listener failed, e.g. the address is already in use
+	case <-ctx.Done():
+		stop() // a second signal kills the process immediately
+		slog.Info("Shutting down server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+		defer cancel()
+		if err = srv.Shutdown(shutdownCtx); err != nil {
+			err = fmt.Errorf("requests did not finish within %s: %w", cfg.ShutdownTimeout.Duration, err)
+			srv.Close()
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	handlers.wait()
+
+	for _, c := range closers {
+		if cerr := c.Close(); cerr != nil {
+			err = errors.Join(err, cerr)
+		}
+	}
+	if err == nil {
+		slog.Info("Server stopped")
+	}
+	return err
+}
+
+// inflight counts the running handlers. Once wait has been called, requests
+// that reach a handler on a connection that is not closed yet get a 503.
+type inflight struct {
+	mu       sync.Mutex
+	idle     sync.Cond
+	n        int
+	draining bool
+}
+
+func newInflight() *inflight {
+	f := &inflight{}
+	f.idle.L = &f.mu
+	return f
+}
+
+func (f *inflight) track(next http.Handler) http.Handler {
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		if f.draining {
+			f.mu.Unlock()
+			w.Header().Set("Connection", "close")
+			problem.Write(w, r, apperr.Unavailable("the server is shutting down", nil))
+			return
+		}
+		f.n++
+		f.mu.Unlock()
+
+		defer func() {
+			f.mu.Lock()
+			if f.n--; f.n == 0 {
+				f.idle.Broadcast()
+			}
+			f.mu.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Blocks until no handler runs, and keeps further ones from starting
+func (f *inflight) wait() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.draining = true
+	for f.n > 0 {
+		f.idle.Wait()
+	}
+}
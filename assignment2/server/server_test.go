@@ -0,0 +1,158 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"assignment2/config"
+)
+
+func TestLimitBody(t *testing.T) {
+	var read string
+	handler := LimitBody(4, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		read = string(data)
+	}))
+
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		want          int
+	}{
+		{"within the limit", "abcd", 4, http.StatusOK},
+		{"declared too large", "abcde", 5, http.StatusRequestEntityTooLarge},
+		{"read past the limit", "abcde", -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read = ""
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.ContentLength = tt.contentLength
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && read != tt.body {
+				t.Errorf("handler read %q, want %q", read, tt.body)
+			}
+		})
+	}
+}
+
+func TestInflightWaitsForHandlers(t *testing.T) {
+	f := newInflight()
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := f.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	waited := make(chan struct{})
+	go func() {
+		f.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait returned while a handler was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return after the handler did")
+	}
+
+	// Requests arriving after the drain began do not reach the handler
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status after wait = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// A handler that outlives the shutdown timeout still returns before the
+// closers run
+func TestRunClosesAfterHandlersReturn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	srv := New(config.ServerConfig{Addr: addr}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(true)
+	}))
+	cfg := config.ServerConfig{ShutdownTimeout: config.Duration{Duration: 10 * time.Millisecond}}
+
+	var closedAfterHandler atomic.Bool
+	ran := make(chan error, 1)
+	go func() {
+		ran <- Run(srv, cfg, closerFunc(func() error {
+			closedAfterHandler.Store(finished.Load())
+			return nil
+		}))
+	}()
+
+	// Retries until Run listens
+	go func() {
+		for i := 0; i < 100; i++ {
+			if resp, err := http.Get("http://" + addr); err == nil {
+				resp.Body.Close()
+				return
+			}
+			select {
+			case <-started:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request did not reach the handler")
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-ran:
+		if err == nil {
+			t.Error("Run reported no error though the shutdown timed out")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	if !closedAfterHandler.Load() {
+		t.Error("the closers ran before the handler returned")
+	}
+}
@@ -0,0 +1,177 @@
+package servertiming
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+// Connector wraps c so that the statements run on its connections are
+// counted and timed for the request in their context, and the moment a
+// request gets its connection ends its pool wait. Rows are not wrapped, so
+// the database time excludes reading result sets.
+func Connector(c driver.Connector) driver.Connector {
+	return connector{c}
+}
+
+type connector struct {
+	driver.Connector
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	// New connections are dialed while the caller waits
+	conn, err := c.Connector.Connect(ctx)
+	acquired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{conn}, nil
+}
+
+// timedConn forwards to the driver's connection. The mysql driver implements all
+// of the optional interfaces, which database/sql only uses when the wrapper
+// has them too.
+type timedConn struct {
+	driver.Conn
+}
+
+var (
+	_ driver.ConnPrepareContext = (*timedConn)(nil)
+	_ driver.ConnBeginTx        = (*timedConn)(nil)
+	_ driver.QueryerContext     = (*timedConn)(nil)
+	_ driver.ExecerContext      = (*timedConn)(nil)
+	_ driver.Pinger             = (*timedConn)(nil)
+	_ driver.SessionResetter    = (*timedConn)(nil)
+	_ driver.Validator          = (*timedConn)(nil)
+	_ driver.NamedValueChecker  = (*timedConn)(nil)
+)
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	acquired(ctx)
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{stmt}, nil
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	acquired(ctx)
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Rows, error) {
+	acquired(ctx)
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, q, args)
+	// ErrSkip makes database/sql prepare the statement instead; it is
+	// counted when the statement runs
+	if !errors.Is(err, driver.ErrSkip) {
+		query(ctx, time.Since(start))
+	}
+	return rows, err
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Result, error) {
+	acquired(ctx)
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, q, args)
+	if !errors.Is(err, driver.ErrSkip) {
+		query(ctx, time.Since(start))
+	}
+	return result, err
+}
+
+func (c *timedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) ResetSession(ctx context.Context) error {
+	// database/sql resets reused connections as it hands them out
+	acquired(ctx)
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *timedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// timedStmt forwards to the driver's prepared statement
+type timedStmt struct {
+	driver.Stmt
+}
+
+var (
+	_ driver.StmtQueryContext  = (*timedStmt)(nil)
+	_ driver.StmtExecContext   = (*timedStmt)(nil)
+	_ driver.NamedValueChecker = (*timedStmt)(nil)
+	_ driver.ColumnConverter   = (*timedStmt)(nil)
+)
+
+func (s *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, errors.New("servertiming: driver statement does not support QueryContext")
+	}
+	start := time.Now()
+	defer func() { query(ctx, time.Since(start)) }()
+	return q.QueryContext(ctx, args)
+}
+
+func (s *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, errors.New("servertiming: driver statement does not support ExecContext")
+	}
+	start := time.Now()
+	defer func() { query(ctx, time.Since(start)) }()
+	return e.ExecContext(ctx, args)
+}
+
+func (s *timedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (s *timedStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if c, ok := s.Stmt.(driver.ColumnConverter); ok {
+		return c.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
@@ -0,0 +1,37 @@
+package servertiming
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// GORMPlugin returns a GORM plugin that marks the start of each operation
+// with Begin, so that the wait for its connection is measured
+func GORMPlugin() gorm.Plugin {
+	return gormPlugin{}
+}
+
+type gormPlugin struct{}
+
+func (gormPlugin) Name() string {
+	return "servertiming"
+}
+
+func (gormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("servertiming:begin_create", beginGORM),
+		cb.Query().Before("*").Register("servertiming:begin_query", beginGORM),
+		cb.Update().Before("*").Register("servertiming:begin_update", beginGORM),
+		cb.Delete().Before("*").Register("servertiming:begin_delete", beginGORM),
+		cb.Row().Before("*").Register("servertiming:begin_row", beginGORM),
+		cb.Raw().Before("*").Register("servertiming:begin_raw", beginGORM),
+	)
+}
+
+func beginGORM(db *gorm.DB) {
+	if db.Statement.Context != nil {
+		Begin(db.Statement.Context)
+	}
+}
@@ -0,0 +1,179 @@
+package servertiming
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"assignment2/config"
+	"assignment2/logging"
+)
+
+// Middleware attaches Timings to each request and sends them in the
+// Server-Timing header
+type Middleware struct {
+	cfg   config.ServerTimingConfig
+	allow func(r *http.Request) bool
+}
+
+// New returns the middleware for cfg. allow decides whether a caller sending
+// X-Debug-Timing may see the header; it runs before the timings start, so
+// its own queries are not counted. A nil allow ignores the debug header.
+func New(cfg config.ServerTimingConfig, allow func(r *http.Request) bool) *Middleware {
+	return &Middleware{cfg: cfg, allow: allow}
+}
+
+// Handler wraps next; use it inside the logging middleware and around the
+// rest, so that the header is set before any of them writes the response
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, r, send := m.begin(r)
+		tw := &writer{ResponseWriter: w, t: t, send: send, threshold: m.cfg.QueryThreshold}
+		next.ServeHTTP(tw, r)
+		if !tw.wrote {
+			tw.writeHeader()
+		}
+		m.end(t, r)
+	})
+}
+
+// GinMiddleware is Handler for Gin
+func (m *Middleware) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t, r, send := m.begin(c.Request)
+		c.Request = r
+		tw := &ginWriter{ResponseWriter: c.Writer, t: t, send: send, threshold: m.cfg.QueryThreshold}
+		c.Writer = tw
+		c.Next()
+		// Gin writes the headers of empty responses after the handlers
+		tw.setHeader()
+		m.end(t, r)
+	}
+}
+
+// Starts timing r, reporting whether to send the header
+func (m *Middleware) begin(r *http.Request) (*Timings, *http.Request, bool) {
+	send := m.cfg.Enabled || (r.Header.Get(HeaderDebug) != "" && m.allow != nil && m.allow(r))
+	t := &Timings{}
+	return t, r.WithContext(NewContext(r.Context(), t)), send
+}
+
+// Logs a likely N+1 pattern
+func (m *Middleware) end(t *Timings, r *http.Request) {
+	if m.cfg.QueryThreshold > 0 && t.Queries() > int64(m.cfg.QueryThreshold) {
+		logging.FromContext(r.Context()).Warn("Many queries for one request, possibly N+1",
+			"queries", t.Queries(), "threshold", m.cfg.QueryThreshold, "db_time", t.DBTime())
+	}
+}
+
+// writer holds back the status until the body is written, so that the
+// header can include the time it took to encode the body
+type writer struct {
+	http.ResponseWriter
+	t         *Timings
+	send      bool
+	threshold int
+
+	status   int
+	statusAt time.Time
+	wrote    bool
+}
+
+func (w *writer) WriteHeader(status int) {
+	if w.wrote || w.status != 0 {
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses go out at once
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	w.statusAt = time.Now()
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.writeHeader()
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the held back status before flushing
+func (w *writer) Flush() {
+	if !w.wrote {
+		w.writeHeader()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *writer) writeHeader() {
+	w.wrote = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	} else {
+		w.t.encoding = time.Since(w.statusAt)
+	}
+	if w.send {
+		w.Header().Set("Server-Timing", w.t.Header(w.threshold))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// ginWriter is writer for Gin, whose own writer already holds back the
+// status until the body is written
+type ginWriter struct {
+	gin.ResponseWriter
+	t         *Timings
+	send      bool
+	threshold int
+
+	statusAt time.Time
+	done     bool
+}
+
+func (w *ginWriter) WriteHeader(status int) {
+	if w.statusAt.IsZero() {
+		w.statusAt = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ginWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *ginWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *ginWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *ginWriter) Flush() {
+	w.setHeader()
+	w.ResponseWriter.Flush()
+}
+
+func (w *ginWriter) setHeader() {
+	if w.done || w.ResponseWriter.Written() {
+		return
+	}
+	w.done = true
+	if !w.statusAt.IsZero() {
+		w.t.encoding = time.Since(w.statusAt)
+	}
+	if w.send {
+		w.Header().Set("Server-Timing", w.t.Header(w.threshold))
+	}
+}
@@ -0,0 +1,110 @@
+// Package servertiming breaks down where a request spent its time and
+// reports it in a Server-Timing response header: the time spent in database
+// calls and their number, the time spent waiting for a pooled connection and
+// the time spent encoding the response.
+//
+// Queries are counted for every request, so that likely N+1 patterns, such
+// as loading a profile per listed user, are logged even when the header is
+// not sent. The header is sent when enabled by configuration, or when an
+// authorized caller asks for it with the X-Debug-Timing header.
+package servertiming
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HeaderDebug asks for the Server-Timing header on a single request
+const HeaderDebug = "X-Debug-Timing"
+
+// Timings accumulates the timings of one request. Database calls may record
+// into it from several goroutines.
+type Timings struct {
+	queries  atomic.Int64
+	dbTime   atomic.Int64 // nanoseconds
+	poolWait atomic.Int64 // nanoseconds
+	waiting  atomic.Int64 // UnixNano when a call started waiting for a connection, or 0
+
+	// Set by the response writer
+	encoding time.Duration
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying t
+func NewContext(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the timings of the request ctx belongs to, or nil
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(contextKey{}).(*Timings)
+	return t
+}
+
+// Begin marks that a database call on behalf of ctx's request starts and may
+// wait for a connection. The wait ends when the driver gets the call.
+func Begin(ctx context.Context) {
+	if t := FromContext(ctx); t != nil {
+		t.waiting.Store(time.Now().UnixNano())
+	}
+}
+
+// Records that a call of ctx's request got its connection
+func acquired(ctx context.Context) {
+	t := FromContext(ctx)
+	if t == nil {
+		return
+	}
+	if start := t.waiting.Swap(0); start != 0 {
+		t.poolWait.Add(time.Now().UnixNano() - start)
+	}
+}
+
+// Records a query or statement of ctx's request that took d
+func query(ctx context.Context, d time.Duration) {
+	if t := FromContext(ctx); t != nil {
+		t.queries.Add(1)
+		t.dbTime.Add(int64(d))
+	}
+}
+
+// Queries returns the number of queries and statements run so far
+func (t *Timings) Queries() int64 {
+	return t.queries.Load()
+}
+
+// DBTime returns the time spent in queries and statements so far
+func (t *Timings) DBTime() time.Duration {
+	return time.Duration(t.dbTime.Load())
+}
+
+// PoolWait returns the time spent waiting for connections so far
+func (t *Timings) PoolWait() time.Duration {
+	return time.Duration(t.poolWait.Load())
+}
+
+// Header formats the timings as a Server-Timing header value. Query counts
+// over threshold are flagged as a likely N+1 pattern; threshold 0 disables
+// the flag.
+func (t *Timings) Header(threshold int) string {
+	metrics := []string{
+		fmt.Sprintf("db;dur=%s;desc=\"database\"", millis(t.DBTime())),
+		fmt.Sprintf("queries;desc=\"%d\"", t.Queries()),
+		fmt.Sprintf("pool-wait;dur=%s", millis(t.PoolWait())),
+	}
+	if t.encoding > 0 {
+		metrics = append(metrics, fmt.Sprintf("encode;dur=%s", millis(t.encoding)))
+	}
+	if threshold > 0 && t.Queries() > int64(threshold) {
+		metrics = append(metrics, fmt.Sprintf("n-plus-one;desc=\"%d queries, threshold %d\"", t.Queries(), threshold))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d.Microseconds())/1000)
+}
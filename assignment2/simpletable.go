@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 
+	"assignment2/config"
+
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -54,8 +56,9 @@ func queryData(db *sql.DB) {
 }
 
 func main() {
+	cfg := config.MustLoad()
 
-	db, err := sql.Open("mysql", "root:password@tcp(127.0.0.1:3306)/gocon")
+	db, err := sql.Open("mysql", cfg.Database.DSN())
 	if err != nil {
 		log.Fatal(err)
 	}
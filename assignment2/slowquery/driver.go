@@ -0,0 +1,203 @@
+package slowquery
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+// A pool opened through Connector
+type pool struct {
+	name    string
+	explain *sql.DB // nil unless EXPLAIN is enabled
+}
+
+// Connector wraps c so that the statements run on its connections are
+// timed and recorded as those of the pool name. With EXPLAIN enabled,
+// plans are taken on a connection of c's own, so that they are not traced,
+// timed or recorded themselves.
+func (d *Detector) Connector(name string, c driver.Connector) driver.Connector {
+	p := &pool{name: name}
+	if d.cfg.Explain {
+		p.explain = sql.OpenDB(c)
+		p.explain.SetMaxOpenConns(1)
+		p.explain.SetConnMaxIdleTime(time.Minute)
+		d.mu.Lock()
+		d.explainDB = append(d.explainDB, p.explain)
+		d.mu.Unlock()
+	}
+	return connector{Connector: c, d: d, pool: p}
+}
+
+type connector struct {
+	driver.Connector
+	d    *Detector
+	pool *pool
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &observedConn{Conn: conn, d: c.d, pool: c.pool}, nil
+}
+
+// observedConn forwards to the driver's connection, like the Server-Timing
+// wrapper, so that database/sql keeps using its optional interfaces
+type observedConn struct {
+	driver.Conn
+	d    *Detector
+	pool *pool
+}
+
+var (
+	_ driver.ConnPrepareContext = (*observedConn)(nil)
+	_ driver.ConnBeginTx        = (*observedConn)(nil)
+	_ driver.QueryerContext     = (*observedConn)(nil)
+	_ driver.ExecerContext      = (*observedConn)(nil)
+	_ driver.Pinger             = (*observedConn)(nil)
+	_ driver.SessionResetter    = (*observedConn)(nil)
+	_ driver.Validator          = (*observedConn)(nil)
+	_ driver.NamedValueChecker  = (*observedConn)(nil)
+)
+
+func (c *observedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	start := time.Now()
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		c.d.observe(ctx, c.pool, query, nil, time.Since(start), err)
+		return nil, err
+	}
+	return &observedStmt{Stmt: stmt, conn: c, query: query, prepare: time.Since(start)}, nil
+}
+
+func (c *observedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *observedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	// ErrSkip makes database/sql prepare the statement instead; it is
+	// recorded when the statement runs
+	if !errors.Is(err, driver.ErrSkip) {
+		c.d.observe(ctx, c.pool, query, args, time.Since(start), err)
+	}
+	return rows, err
+}
+
+func (c *observedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if !errors.Is(err, driver.ErrSkip) {
+		c.d.observe(ctx, c.pool, query, args, time.Since(start), err)
+	}
+	return result, err
+}
+
+func (c *observedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *observedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *observedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *observedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// observedStmt forwards to the driver's prepared statement. The driver
+// prepares every statement with arguments, so the time to prepare it is
+// added to its first run.
+type observedStmt struct {
+	driver.Stmt
+	conn    *observedConn
+	query   string
+	prepare time.Duration
+}
+
+var (
+	_ driver.StmtQueryContext  = (*observedStmt)(nil)
+	_ driver.StmtExecContext   = (*observedStmt)(nil)
+	_ driver.NamedValueChecker = (*observedStmt)(nil)
+	_ driver.ColumnConverter   = (*observedStmt)(nil)
+)
+
+func (s *observedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, errors.New("slowquery: driver statement does not support QueryContext")
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, args)
+	s.observe(ctx, args, start, err)
+	return rows, err
+}
+
+func (s *observedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, errors.New("slowquery: driver statement does not support ExecContext")
+	}
+	start := time.Now()
+	result, err := e.ExecContext(ctx, args)
+	s.observe(ctx, args, start, err)
+	return result, err
+}
+
+func (s *observedStmt) observe(ctx context.Context, args []driver.NamedValue, start time.Time, err error) {
+	took := time.Since(start) + s.prepare
+	s.prepare = 0
+	s.conn.d.observe(ctx, s.conn.pool, s.query, args, took, err)
+}
+
+func (s *observedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (s *observedStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if c, ok := s.Stmt.(driver.ColumnConverter); ok {
+		return c.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
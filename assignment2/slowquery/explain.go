@@ -0,0 +1,104 @@
+package slowquery
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+
+	"assignment2/redact"
+)
+
+// How long an EXPLAIN may take
+const explainTimeout = 5 * time.Second
+
+// PlanRow is one row of EXPLAIN output, by column name
+type PlanRow map[string]string
+
+type explainJob struct {
+	db     *sql.DB
+	key    shapeKey
+	query  string
+	args   []interface{}
+	logger *slog.Logger // of the request that ran the statement
+}
+
+// Statements MySQL can explain; EXPLAIN does not run them
+var explainableVerbs = map[string]bool{"select": true, "insert": true, "replace": true, "update": true, "delete": true, "with": true}
+
+func explainable(shape string) bool {
+	verb, _, _ := strings.Cut(shape, " ")
+	return explainableVerbs[strings.ToLower(verb)]
+}
+
+// Queues job unless the queue is full or the detector closed
+func (d *Detector) queueExplain(job explainJob) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.explains <- job:
+	default:
+		job.logger.Debug("Query plan skipped, too many queued", "shape", job.key.shape)
+	}
+}
+
+func (d *Detector) explainLoop() {
+	defer d.wg.Done()
+	for job := range d.explains {
+		plan, err := explain(job)
+
+		d.mu.Lock()
+		if s := d.shapes[job.key]; s != nil {
+			s.plan = plan
+			if err != nil {
+				s.planErr = redact.Text(err.Error())
+			}
+		}
+		d.mu.Unlock()
+
+		if err != nil {
+			job.logger.Warn("Could not explain slow query", "shape", job.key.shape, "err", redact.Error(err))
+			continue
+		}
+		job.logger.Info("Slow query plan", "pool", job.key.pool, "shape", job.key.shape, "plan", plan)
+	}
+}
+
+// Runs EXPLAIN on the statement of job with its original arguments
+func explain(job explainJob) ([]PlanRow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+	rows, err := job.db.QueryContext(ctx, "EXPLAIN "+job.query, job.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var plan []PlanRow
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(PlanRow, len(columns))
+		for i, column := range columns {
+			if values[i].Valid {
+				row[column] = values[i].String
+			}
+		}
+		plan = append(plan, row)
+	}
+	return plan, rows.Err()
+}
@@ -0,0 +1,113 @@
+package slowquery
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Shapes longer than this are cut, e.g. inserts of many rows
+const maxShapeLen = 1024
+
+var (
+	// (?, ?, ?) in IN lists and VALUES rows
+	placeholderListRe = regexp.MustCompile(`\( ?\?(?: ?, ?\?)+ ?\)`)
+	// (...), (...) and (?), (?) in multi-row VALUES
+	rowsRe = regexp.MustCompile(`(\((?:\?|\.\.\.)\))(?: ?, ?\((?:\?|\.\.\.)\))+`)
+)
+
+// Normalize returns the shape of a statement: its string and number
+// literals replaced by ?, lists of placeholders collapsed to (...),
+// comments dropped and whitespace reduced to single spaces, so that
+// statements differing only in their values share one shape
+func Normalize(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	var prev byte // last byte written
+	space := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+			continue
+		case c == '#' || c == '-' && strings.HasPrefix(query[i:], "-- "):
+			i = skipPast(query, i, "\n")
+			space = true
+			continue
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			i = skipPast(query, i+2, "*/")
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+			prev = ' '
+		}
+		space = false
+
+		switch {
+		case c == '\'' || c == '"':
+			i = skipString(query, i)
+			c = '?'
+		case c == '`':
+			// Quoted identifiers are kept, digits and all
+			end := skipPast(query, i+1, "`")
+			b.WriteString(query[i:end])
+			i, prev = end, '`'
+			continue
+		case isDigit(c) && !isWord(prev):
+			for i < len(query) && (isWord(query[i]) || query[i] == '.') {
+				i++
+			}
+			c = '?'
+		default:
+			i++
+		}
+		b.WriteByte(c)
+		prev = c
+	}
+
+	shape := placeholderListRe.ReplaceAllString(b.String(), "(...)")
+	shape = rowsRe.ReplaceAllString(shape, "$1, ...")
+	if len(shape) > maxShapeLen {
+		shape = shape[:maxShapeLen] + "..."
+	}
+	return shape
+}
+
+// Returns the index after the string literal starting at i, which may
+// escape its quote with a backslash or by doubling it
+func skipString(s string, i int) int {
+	quote := s[i]
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// Returns the index after the first end at or after i, or len(s)
+func skipPast(s string, i int, end string) int {
+	if j := strings.Index(s[i:], end); j >= 0 {
+		return i + j + len(end)
+	}
+	return len(s)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Reports whether c can be part of an unquoted identifier or number
+func isWord(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
@@ -0,0 +1,266 @@
+// Package slowquery finds expensive statements. It wraps the database
+// driver, so that the statements of both the database/sql and the GORM
+// repositories are seen, and keeps statistics per statement shape: the
+// statement with its literals replaced by placeholders. Statements slower
+// than the threshold are logged with their shape, redacted arguments,
+// duration and the application code that ran them, and the first slow
+// statement of each shape can have its plan recorded with EXPLAIN.
+package slowquery
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"assignment2/config"
+	"assignment2/logging"
+	"assignment2/redact"
+)
+
+// Detector keeps the statistics of the pools opened through its Connector
+// and runs their EXPLAINs
+type Detector struct {
+	cfg config.SlowQueryConfig
+
+	mu        sync.Mutex
+	shapes    map[shapeKey]*shape
+	untracked int64     // statements whose shape did not fit
+	closed    bool      // stops new EXPLAINs
+	explainDB []*sql.DB // closed with the detector
+
+	explains chan explainJob // nil unless EXPLAIN is enabled
+	wg       sync.WaitGroup
+}
+
+type shapeKey struct {
+	pool, shape string
+}
+
+type shape struct {
+	count, slow, errors int64
+	total, max          time.Duration
+	caller              string // of the last slow statement
+	explained           bool   // an EXPLAIN was queued
+	plan                []PlanRow
+	planErr             string
+}
+
+// New returns a detector configured by cfg. With EXPLAIN enabled it starts
+// a goroutine, which Close stops.
+func New(cfg config.SlowQueryConfig) *Detector {
+	d := &Detector{cfg: cfg, shapes: make(map[shapeKey]*shape)}
+	if cfg.Explain {
+		// EXPLAINs of a burst of slow statements are dropped rather than
+		// queued without bound
+		d.explains = make(chan explainJob, 16)
+		d.wg.Add(1)
+		go d.explainLoop()
+	}
+	return d
+}
+
+// Close waits for the running EXPLAIN and closes the connections used for
+// them
+func (d *Detector) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	if d.explains != nil {
+		close(d.explains)
+	}
+	dbs := d.explainDB
+	d.mu.Unlock()
+
+	d.wg.Wait()
+	var errs []error
+	for _, db := range dbs {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Records one statement run on pool, which took took and failed with err
+func (d *Detector) observe(ctx context.Context, p *pool, query string, args []driver.NamedValue, took time.Duration, err error) {
+	key := shapeKey{pool: p.name, shape: Normalize(query)}
+	threshold := d.cfg.Threshold.Duration
+	slow := threshold > 0 && took >= threshold
+	var where string
+	if slow {
+		where = caller()
+	}
+
+	d.mu.Lock()
+	s, ok := d.shapes[key]
+	if !ok && len(d.shapes) < d.cfg.MaxShapes {
+		s = &shape{}
+		d.shapes[key] = s
+	}
+	explain := false
+	if s == nil {
+		d.untracked++
+	} else {
+		s.count++
+		s.total += took
+		s.max = max(s.max, took)
+		if err != nil {
+			s.errors++
+		}
+		if slow {
+			s.slow++
+			s.caller = where
+			explain = p.explain != nil && !s.explained && explainable(key.shape)
+			s.explained = s.explained || explain
+		}
+	}
+	d.mu.Unlock()
+
+	if !slow {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	attrs := []interface{}{"pool", p.name, "shape", key.shape, "args", redactArgs(args), "duration", took, "caller", where}
+	if err != nil {
+		attrs = append(attrs, "err", redact.Error(err))
+	}
+	logger.Warn("Slow query", attrs...)
+	if explain {
+		d.queueExplain(explainJob{db: p.explain, key: key, query: query, args: copyArgs(args), logger: logger})
+	}
+}
+
+// Report is the statistics of every statement shape, the one with the most
+// time in total first
+type Report struct {
+	Threshold string       `json:"threshold"`
+	Shapes    []ShapeStats `json:"shapes"`
+	// Untracked counts statements whose shape did not fit within max_shapes
+	Untracked int64 `json:"untracked"`
+}
+
+// ShapeStats are the statistics of one statement shape on one pool
+type ShapeStats struct {
+	Pool    string  `json:"pool"`
+	Shape   string  `json:"shape"`
+	Count   int64   `json:"count"`
+	Slow    int64   `json:"slow"`
+	Errors  int64   `json:"errors"`
+	TotalMS float64 `json:"total_ms"`
+	MeanMS  float64 `json:"mean_ms"`
+	MaxMS   float64 `json:"max_ms"`
+	// Caller ran the last slow statement of the shape
+	Caller string `json:"caller,omitempty"`
+	// Plan is the EXPLAIN output of the first slow statement, if recorded
+	Plan      []PlanRow `json:"plan,omitempty"`
+	PlanError string    `json:"plan_error,omitempty"`
+}
+
+// Stats returns the statistics collected since the start
+func (d *Detector) Stats() Report {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := Report{Threshold: d.cfg.Threshold.Duration.String(), Shapes: make([]ShapeStats, 0, len(d.shapes)), Untracked: d.untracked}
+	for key, s := range d.shapes {
+		r.Shapes = append(r.Shapes, ShapeStats{
+			Pool:      key.pool,
+			Shape:     key.shape,
+			Count:     s.count,
+			Slow:      s.slow,
+			Errors:    s.errors,
+			TotalMS:   milliseconds(s.total),
+			MeanMS:    milliseconds(s.total / time.Duration(s.count)),
+			MaxMS:     milliseconds(s.max),
+			Caller:    s.caller,
+			Plan:      s.plan,
+			PlanError: s.planErr,
+		})
+	}
+	sort.Slice(r.Shapes, func(i, j int) bool { return r.Shapes[i].TotalMS > r.Shapes[j].TotalMS })
+	return r
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Frames between the application and the driver: the database libraries,
+// the other driver wrappers and the repositories' pool wrapper
+var internalFrames = []string{
+	"database/sql.",
+	"gorm.io/",
+	"github.com/go-sql-driver/",
+	"github.com/XSAM/otelsql",
+	"go.opentelemetry.io/",
+	"assignment2/slowquery.",
+	"assignment2/servertiming.",
+	"assignment2/tracing.",
+	"assignment2/repository.pool.",
+}
+
+// Returns the function, file and line of the application code that ran the
+// current statement
+func caller() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		f, more := frames.Next()
+		if !isInternal(f.Function) {
+			// assignment2/repository.(*GORMRepository).ListUsers (repository/gorm.go:40)
+			_, fn := path.Split(f.Function)
+			dir, file := path.Split(f.File)
+			return fmt.Sprintf("%s (%s:%d)", fn, path.Join(path.Base(dir), file), f.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+func isInternal(function string) bool {
+	for _, prefix := range internalFrames {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns args for logging. Strings and bytes, which hold names, bios and
+// hashes, become keyed hashes, so that repeated values can still be
+// recognised; numbers, booleans and times are kept to help reproduce a plan.
+func redactArgs(args []driver.NamedValue) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, a := range args {
+		switch v := a.Value.(type) {
+		case string:
+			out[i] = redact.Hash(v)
+		case []byte:
+			out[i] = redact.Hash(string(v))
+		default:
+			out[i] = v
+		}
+	}
+	return out
+}
+
+// Copies args for an EXPLAIN run after the statement returned
+func copyArgs(args []driver.NamedValue) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, a := range args {
+		if b, ok := a.Value.([]byte); ok {
+			a.Value = append([]byte(nil), b...)
+		}
+		out[i] = a.Value
+	}
+	return out
+}
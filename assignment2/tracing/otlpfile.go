@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// fileClient is an otlptrace.Client appending each batch of spans to a file
+// as an OTLP/JSON ExportTraceServiceRequest on its own line, the format read
+// by the collector's otlpjsonfile receiver
+type fileClient struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (c *fileClient) Start(ctx context.Context) error {
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file = f
+	return nil
+}
+
+func (c *fileClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
+
+func (c *fileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	// OTLP/JSON wants enums as numbers and IDs in hex rather than the
+	// base64 of the standard protobuf JSON mapping
+	b, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	hexIDs(doc)
+	if b, err = json.Marshal(doc); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.file.Write(append(b, '\n'))
+	return err
+}
+
+// Re-encodes the base64 trace and span IDs in doc as hex
+func hexIDs(doc interface{}) {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			switch s, ok := value.(string); {
+			case ok && (key == "traceId" || key == "spanId" || key == "parentSpanId"):
+				if id, err := base64.StdEncoding.DecodeString(s); err == nil {
+					v[key] = hex.EncodeToString(id)
+				}
+			default:
+				hexIDs(value)
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			hexIDs(value)
+		}
+	}
+}
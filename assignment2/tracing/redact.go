@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+
+	"assignment2/redact"
+)
+
+// redactingExporter masks personal data in the error messages recorded on
+// spans, such as the duplicate value in a MySQL 1062 error, before export
+type redactingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e redactingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	redacted := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, span := range spans {
+		redacted[i] = redactedSpan{span}
+	}
+	return e.SpanExporter.ExportSpans(ctx, redacted)
+}
+
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s redactedSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+	out := make([]sdktrace.Event, len(events))
+	for i, event := range events {
+		out[i] = event
+		out[i].Attributes = make([]attribute.KeyValue, len(event.Attributes))
+		for j, attr := range event.Attributes {
+			if attr.Key == semconv.ExceptionMessageKey {
+				attr = semconv.ExceptionMessage(redact.Text(attr.Value.AsString()))
+			}
+			out[i].Attributes[j] = attr
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// OpenDB opens a MySQL pool on c whose queries, executions and transactions
+// each get a span. The spans carry the SQL text with its placeholders, not
+// the arguments.
+func OpenDB(c driver.Connector) *sql.DB {
+	return otelsql.OpenDB(c,
+		otelsql.WithAttributes(semconv.DBSystemNameMySQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{DisableErrSkip: true, OmitConnResetSession: true}))
+}
+
+// GORMPlugin returns a GORM plugin that wraps each create, query, update,
+// delete, row and raw operation in a span. With a pool from OpenDB, the
+// database/sql spans of the operation become its children.
+func GORMPlugin() gorm.Plugin {
+	return gormPlugin{}
+}
+
+type gormPlugin struct{}
+
+// Instance key of the span started by the before callbacks
+const spanKey = "tracing:span"
+
+type gormSpan struct {
+	span   trace.Span
+	parent context.Context
+}
+
+func (gormPlugin) Name() string {
+	return "tracing"
+}
+
+func (gormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("tracing:before_create", beforeGORM("create")),
+		cb.Create().After("*").Register("tracing:after_create", afterGORM),
+		cb.Query().Before("*").Register("tracing:before_query", beforeGORM("query")),
+		cb.Query().After("*").Register("tracing:after_query", afterGORM),
+		cb.Update().Before("*").Register("tracing:before_update", beforeGORM("update")),
+		cb.Update().After("*").Register("tracing:after_update", afterGORM),
+		cb.Delete().Before("*").Register("tracing:before_delete", beforeGORM("delete")),
+		cb.Delete().After("*").Register("tracing:after_delete", afterGORM),
+		cb.Row().Before("*").Register("tracing:before_row", beforeGORM("row")),
+		cb.Row().After("*").Register("tracing:after_row", afterGORM),
+		cb.Raw().Before("*").Register("tracing:before_raw", beforeGORM("raw")),
+		cb.Raw().After("*").Register("tracing:after_raw", afterGORM),
+	)
+}
+
+func beforeGORM(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		parent := db.Statement.Context
+		if parent == nil {
+			parent = context.Background()
+		}
+		ctx, span := tracer().Start(parent, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemNameMySQL, semconv.DBOperationName(operation)))
+		db.Statement.Context = ctx
+		db.InstanceSet(spanKey, gormSpan{span: span, parent: parent})
+	}
+}
+
+func afterGORM(db *gorm.DB) {
+	v, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	s := v.(gormSpan)
+	db.Statement.Context = s.parent
+
+	// The SQL has placeholders; the arguments stay in Statement.Vars
+	s.span.SetAttributes(
+		semconv.DBQueryText(db.Statement.SQL.String()),
+		semconv.DBCollectionName(db.Statement.Table),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		s.span.RecordError(db.Error)
+		s.span.SetStatus(codes.Error, "query failed")
+	}
+	s.span.End()
+}
@@ -0,0 +1,176 @@
+// Package txn runs functions in database transactions, for database/sql
+// and GORM alike. The transaction commits when the function returns nil and
+// rolls back when it returns an error or panics; a transaction begun within
+// another becomes a savepoint of it.
+//
+// When MySQL aborts a transaction with a deadlock or a lock wait timeout,
+// the function runs again in a new transaction after a jittered backoff. It
+// must therefore not have effects outside the transaction that cannot be
+// repeated.
+package txn
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"assignment2/dberr"
+	"assignment2/logging"
+	"assignment2/redact"
+)
+
+// DefaultAttempts is how often a function runs at most when its
+// transactions keep failing with lock conflicts
+const DefaultAttempts = 3
+
+// The wait before the second attempt; it doubles with each further attempt
+// up to maxBackoff
+const (
+	baseBackoff = 20 * time.Millisecond
+	maxBackoff  = time.Second
+)
+
+// Options configure a transaction. A nil *Options begins it with the
+// server's isolation level, allowing writes, and makes DefaultAttempts.
+type Options struct {
+	// Isolation is the isolation level; the zero value is the server's
+	// default, REPEATABLE READ for InnoDB
+	Isolation sql.IsolationLevel
+	// ReadOnly makes MySQL reject writes in the transaction
+	ReadOnly bool
+	// Attempts bounds the runs of the function; 0 means DefaultAttempts
+	Attempts int
+}
+
+func (o *Options) txOptions() *sql.TxOptions {
+	if o == nil {
+		return nil
+	}
+	return &sql.TxOptions{Isolation: o.Isolation, ReadOnly: o.ReadOnly}
+}
+
+func (o *Options) attempts() int {
+	if o == nil || o.Attempts <= 0 {
+		return DefaultAttempts
+	}
+	return o.Attempts
+}
+
+// SQLDB is what WithTx runs on: a *sql.DB or another type that begins
+// transactions like it, or a *sql.Tx for a savepoint
+type SQLDB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// WithTx runs fn in a transaction on db. If db is a *sql.Tx, fn runs in a
+// savepoint of it instead; opts are then ignored, since the enclosing
+// transaction sets them and retries the whole of it.
+func WithTx(ctx context.Context, db SQLDB, opts *Options, fn func(*sql.Tx) error) error {
+	switch db := db.(type) {
+	case *sql.Tx:
+		return savepoint(ctx, db, fn)
+	case beginner:
+		return retry(ctx, opts, func() error {
+			return sqlTx(ctx, db, opts.txOptions(), fn)
+		})
+	default:
+		return fmt.Errorf("txn: %T cannot begin transactions", db)
+	}
+}
+
+func sqlTx(ctx context.Context, db beginner, opts *sql.TxOptions, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	// Rolls back when fn fails or panics; after Commit it does nothing
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Names savepoints uniquely within their transaction
+var savepoints atomic.Uint64
+
+func savepoint(ctx context.Context, tx *sql.Tx, fn func(*sql.Tx) error) error {
+	name := fmt.Sprintf("sp%d", savepoints.Add(1))
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		// A deadlock has rolled back the whole transaction, savepoint and
+		// all; the enclosing transaction retries
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil && !dberr.IsRetryable(err) {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// WithGORMTx is WithTx for GORM: fn gets the *gorm.DB of the transaction.
+// If db already is in a transaction, GORM runs fn in a savepoint of it.
+func WithGORMTx(ctx context.Context, db *gorm.DB, opts *Options, fn func(*gorm.DB) error) error {
+	db = db.WithContext(ctx)
+	if tx, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok && tx != nil {
+		return db.Transaction(fn)
+	}
+	return retry(ctx, opts, func() error {
+		return db.Transaction(fn, opts.txOptions())
+	})
+}
+
+// Runs run until it succeeds, fails with an error other than a lock
+// conflict, has made opts' attempts or ctx ends
+func retry(ctx context.Context, opts *Options, run func() error) error {
+	attempts := opts.attempts()
+	for attempt := 1; ; attempt++ {
+		err := run()
+		if err == nil || attempt == attempts || !dberr.IsRetryable(err) {
+			return err
+		}
+
+		wait := backoff(attempt)
+		logging.FromContext(ctx).Warn("Retrying transaction after a lock conflict",
+			"attempt", attempt, "wait", wait, "err", redact.Error(err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Returns the wait after the given failed attempt. Up to half of it is
+// random, so that the transactions that conflicted do not retry in step.
+func backoff(attempt int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	return d/2 + rand.N(d/2)
+}
@@ -0,0 +1,306 @@
+package txn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"assignment2/dberr"
+)
+
+// A database/sql driver that records the statements and transaction calls
+// it receives, and fails commits with the queued errors
+type recorder struct {
+	mu         sync.Mutex
+	log        []string
+	commitErrs []error
+}
+
+func (r *recorder) add(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.log = append(r.log, s)
+}
+
+func (r *recorder) statements() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.log...)
+}
+
+func (r *recorder) Connect(context.Context) (driver.Conn, error) { return fakeConn{r}, nil }
+func (r *recorder) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ r *recorder }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		c.r.add("BEGIN READ ONLY")
+	} else {
+		c.r.add("BEGIN")
+	}
+	return fakeTx(c), nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.r.add(query)
+	return driver.RowsAffected(0), nil
+}
+
+type fakeTx struct{ r *recorder }
+
+func (t fakeTx) Commit() error {
+	t.r.add("COMMIT")
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	if len(t.r.commitErrs) == 0 {
+		return nil
+	}
+	err := t.r.commitErrs[0]
+	t.r.commitErrs = t.r.commitErrs[1:]
+	return err
+}
+
+func (t fakeTx) Rollback() error {
+	t.r.add("ROLLBACK")
+	return nil
+}
+
+func newTestDB(t *testing.T) (*sql.DB, *recorder) {
+	t.Helper()
+	r := &recorder{}
+	db := sql.OpenDB(r)
+	t.Cleanup(func() { db.Close() })
+	savepoints.Store(0)
+	return db, r
+}
+
+func checkLog(t *testing.T, r *recorder, want ...string) {
+	t.Helper()
+	if got := r.statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+}
+
+var (
+	deadlock        = &mysql.MySQLError{Number: dberr.ErLockDeadlock, Message: "Deadlock found when trying to get lock"}
+	lockWaitTimeout = &mysql.MySQLError{Number: dberr.ErLockWaitTimeout, Message: "Lock wait timeout exceeded"}
+)
+
+func TestWithTxRetriesLockConflicts(t *testing.T) {
+	for _, conflict := range []error{deadlock, lockWaitTimeout} {
+		t.Run(conflict.Error(), func(t *testing.T) {
+			db, r := newTestDB(t)
+			calls := 0
+			err := WithTx(context.Background(), db, nil, func(tx *sql.Tx) error {
+				calls++
+				if calls < DefaultAttempts {
+					return conflict
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls != DefaultAttempts {
+				t.Errorf("fn ran %d times, want %d", calls, DefaultAttempts)
+			}
+			checkLog(t, r, "BEGIN", "ROLLBACK", "BEGIN", "ROLLBACK", "BEGIN", "COMMIT")
+		})
+	}
+}
+
+func TestWithTxRetriesFailedCommit(t *testing.T) {
+	db, r := newTestDB(t)
+	r.commitErrs = []error{deadlock}
+	calls := 0
+	err := WithTx(context.Background(), db, nil, func(tx *sql.Tx) error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("fn ran %d times, want 2", calls)
+	}
+	checkLog(t, r, "BEGIN", "COMMIT", "BEGIN", "COMMIT")
+}
+
+func TestWithTxGivesUp(t *testing.T) {
+	dupEntry := &mysql.MySQLError{Number: dberr.ErDupEntry, Message: "Duplicate entry 'bob' for key 'users.name'"}
+	tests := []struct {
+		name      string
+		opts      *Options
+		err       error
+		wantCalls int
+	}{
+		{"after the attempts", &Options{Attempts: 2}, deadlock, 2},
+		{"on other errors", nil, dupEntry, 1},
+		{"on errors of fn", nil, errors.New("invalid"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newTestDB(t)
+			calls := 0
+			err := WithTx(context.Background(), db, tt.opts, func(tx *sql.Tx) error {
+				calls++
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("fn ran %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWithTxStopsRetryingWhenContextEnds(t *testing.T) {
+	db, _ := newTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	err := WithTx(ctx, db, nil, func(tx *sql.Tx) error {
+		calls++
+		cancel()
+		return deadlock
+	})
+	if !errors.Is(err, deadlock) {
+		t.Errorf("err = %v, want the deadlock", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn ran %d times, want 1", calls)
+	}
+}
+
+func TestWithTxOptions(t *testing.T) {
+	db, r := newTestDB(t)
+	err := WithTx(context.Background(), db, &Options{ReadOnly: true}, func(tx *sql.Tx) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLog(t, r, "BEGIN READ ONLY", "COMMIT")
+}
+
+func TestWithTxNestedUsesSavepoints(t *testing.T) {
+	db, r := newTestDB(t)
+	ctx := context.Background()
+	innerErr := errors.New("inner failed")
+	err := WithTx(ctx, db, nil, func(tx *sql.Tx) error {
+		if err := WithTx(ctx, tx, nil, func(*sql.Tx) error { return nil }); err != nil {
+			return err
+		}
+		// The failed savepoint is undone, the transaction goes on
+		if err := WithTx(ctx, tx, nil, func(*sql.Tx) error { return innerErr }); !errors.Is(err, innerErr) {
+			t.Errorf("inner err = %v, want %v", err, innerErr)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLog(t, r,
+		"BEGIN",
+		"SAVEPOINT sp1", "RELEASE SAVEPOINT sp1",
+		"SAVEPOINT sp2", "ROLLBACK TO SAVEPOINT sp2",
+		"COMMIT")
+}
+
+// A deadlock in a savepoint retries the whole transaction
+func TestWithTxNestedDeadlockRetriesOuter(t *testing.T) {
+	db, r := newTestDB(t)
+	ctx := context.Background()
+	calls := 0
+	err := WithTx(ctx, db, nil, func(tx *sql.Tx) error {
+		calls++
+		return WithTx(ctx, tx, nil, func(*sql.Tx) error {
+			if calls == 1 {
+				return deadlock
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLog(t, r,
+		"BEGIN", "SAVEPOINT sp1", "ROLLBACK TO SAVEPOINT sp1", "ROLLBACK",
+		"BEGIN", "SAVEPOINT sp2", "RELEASE SAVEPOINT sp2", "COMMIT")
+}
+
+func TestWithTxRollsBackOnPanic(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, tx *sql.Tx) error
+		want []string
+	}{
+		{
+			name: "transaction",
+			fn:   func(ctx context.Context, tx *sql.Tx) error { panic("boom") },
+			want: []string{"BEGIN", "ROLLBACK"},
+		},
+		{
+			name: "savepoint",
+			fn: func(ctx context.Context, tx *sql.Tx) error {
+				return WithTx(ctx, tx, nil, func(*sql.Tx) error { panic("boom") })
+			},
+			want: []string{"BEGIN", "SAVEPOINT sp1", "ROLLBACK TO SAVEPOINT sp1", "ROLLBACK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, r := newTestDB(t)
+			ctx := context.Background()
+			func() {
+				defer func() {
+					if p := recover(); p != "boom" {
+						t.Errorf("recovered %v, want the panic of fn", p)
+					}
+				}()
+				WithTx(ctx, db, nil, func(tx *sql.Tx) error { return tt.fn(ctx, tx) })
+			}()
+			checkLog(t, r, tt.want...)
+		})
+	}
+}
+
+type execOnly struct{}
+
+func (execOnly) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, nil
+}
+
+func TestWithTxNeedsBeginner(t *testing.T) {
+	err := WithTx(context.Background(), execOnly{}, nil, func(*sql.Tx) error {
+		t.Error("fn ran without a transaction")
+		return nil
+	})
+	if err == nil {
+		t.Error("WithTx succeeded on a type that cannot begin transactions")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{1: baseBackoff, 2: 2 * baseBackoff, 3: 4 * baseBackoff, 20: maxBackoff} {
+		for i := 0; i < 100; i++ {
+			if d := backoff(attempt); d < want/2 || d >= want {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v)", attempt, d, want/2, want)
+			}
+		}
+	}
+}
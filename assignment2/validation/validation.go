@@ -0,0 +1,270 @@
+// Package validation checks request bodies against rules declared in
+// `validate` struct tags, so the Gin and net/http servers apply the same
+// rules and report every violation at once.
+//
+// Rules are separated by commas:
+//
+//	required       the value must be present and non-empty
+//	min=N, max=N   bounds on numbers, or on the length of strings in characters
+//	url=s1|s2      the string, if set, must be an absolute URL with one of the schemes
+//
+// Pointer fields, as used for partial updates, are only checked when set, so
+// required on a pointer means "not empty if present".
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"assignment2/apperr"
+)
+
+// DecodeJSON decodes the JSON body from r into v and validates it. Unknown
+// fields, values of the wrong type and rule violations are all reported
+// together as one validation error.
+func DecodeJSON(r io.Reader, v interface{}) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return apperr.InvalidBody(err)
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return apperr.InvalidBody(err)
+	}
+	fields := unknownFields(raw, reflect.TypeOf(v), "")
+
+	// Decoding carries on past type errors and reports the first one
+	var typeErr *json.UnmarshalTypeError
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(v); errors.As(err, &typeErr) {
+		fields = append(fields, apperr.FieldError{Field: typeErr.Field, Message: "must be a " + jsonKind(typeErr.Type)})
+	} else if err != nil {
+		return apperr.InvalidBody(err)
+	}
+
+	for _, f := range Struct(v) {
+		// A value of the wrong type was not decoded, so its rules cannot be checked
+		if typeErr == nil || f.Field != typeErr.Field {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) > 0 {
+		return apperr.Validation("The request body is invalid", fields...)
+	}
+	return nil
+}
+
+// Validate checks v against its tags, returning a validation error listing every violation
+func Validate(v interface{}) error {
+	if fields := Struct(v); len(fields) > 0 {
+		return apperr.Validation("The request body is invalid", fields...)
+	}
+	return nil
+}
+
+// Struct returns the violations of the rules tagged on v, a struct or pointer to struct
+func Struct(v interface{}) []apperr.FieldError {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	return checkStruct(rv, "")
+}
+
+func checkStruct(rv reflect.Value, prefix string) []apperr.FieldError {
+	var errs []apperr.FieldError
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		name, ok := jsonName(sf)
+		if !ok {
+			continue
+		}
+		path := join(prefix, name)
+		fv := rv.Field(i)
+		rules := parseRules(sf.Tag.Get("validate"))
+
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct {
+			errs = append(errs, checkStruct(fv, path)...)
+			continue
+		}
+		errs = append(errs, checkValue(fv, path, rules)...)
+	}
+	return errs
+}
+
+// Parses "required,min=1,max=100" into a rule name to argument map
+func parseRules(tag string) map[string]string {
+	rules := map[string]string{}
+	for _, rule := range strings.Split(tag, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(rule, "=")
+		rules[name] = arg
+	}
+	return rules
+}
+
+func checkValue(fv reflect.Value, path string, rules map[string]string) []apperr.FieldError {
+	var errs []apperr.FieldError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, apperr.FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		s := fv.String()
+		if _, ok := rules["required"]; ok && strings.TrimSpace(s) == "" {
+			fail("is required")
+			return errs
+		}
+		n := utf8.RuneCountInString(s)
+		if min, ok := intRule(rules, "min"); ok && n < min {
+			fail("must be at least %d characters", min)
+		}
+		if max, ok := intRule(rules, "max"); ok && n > max {
+			fail("must be at most %d characters", max)
+		}
+		if schemes, ok := rules["url"]; ok && s != "" && !validURL(s, strings.Split(schemes, "|")) {
+			fail("must be an absolute %s URL", strings.Join(strings.Split(schemes, "|"), " or "))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := fv.Int()
+		if min, ok := intRule(rules, "min"); ok && n < int64(min) {
+			fail("must be at least %d", min)
+		}
+		if max, ok := intRule(rules, "max"); ok && n > int64(max) {
+			fail("must be at most %d", max)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := fv.Uint()
+		if _, ok := rules["required"]; ok && n == 0 {
+			fail("is required")
+		}
+		if max, ok := intRule(rules, "max"); ok && max >= 0 && n > uint64(max) {
+			fail("must be at most %d", max)
+		}
+	}
+	return errs
+}
+
+func intRule(rules map[string]string, name string) (int, bool) {
+	arg, ok := rules[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic(fmt.Sprintf("validation: invalid %s=%q", name, arg))
+	}
+	return n, true
+}
+
+func validURL(s string, schemes []string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists the keys of raw that do not match a field of t, recursing into
+// nested objects. Like encoding/json, keys match names case-insensitively.
+func unknownFields(raw interface{}, t reflect.Type, prefix string) []apperr.FieldError {
+	obj, ok := raw.(map[string]interface{})
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if !ok || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []apperr.FieldError
+	for _, key := range keys {
+		value := obj[key]
+		sf, found := fieldByJSONName(t, key)
+		if !found {
+			errs = append(errs, apperr.FieldError{Field: join(prefix, key), Message: "is not a known field"})
+			continue
+		}
+		errs = append(errs, unknownFields(value, sf.Type, join(prefix, key))...)
+	}
+	return errs
+}
+
+func fieldByJSONName(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if name, ok := jsonName(sf); ok && strings.EqualFold(name, key) {
+			return sf, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Returns the JSON name of a field, or false if it is not encoded
+func jsonName(sf reflect.StructField) (string, bool) {
+	if !sf.IsExported() {
+		return "", false
+	}
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name, true
+	}
+	return sf.Name, true
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// Describes a Go type the way a JSON client would think of it
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "whole number"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
@@ -0,0 +1,154 @@
+package validation_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"assignment2/apperr"
+	"assignment2/validation"
+)
+
+type address struct {
+	City string `json:"city" validate:"required,max=5"`
+}
+
+type body struct {
+	Name     string   `json:"name" validate:"required,min=2,max=5"`
+	Age      int      `json:"age" validate:"min=0,max=150"`
+	Nickname *string  `json:"nickname" validate:"required,max=3"`
+	Site     string   `json:"site" validate:"url=http|https"`
+	OwnerID  uint     `json:"owner_id" validate:"required,max=10"`
+	Address  address  `json:"address"`
+	Other    *address `json:"other"`
+	Secret   string   `json:"-"`
+	internal string
+}
+
+func fieldsOf(t *testing.T, err error) []apperr.FieldError {
+	t.Helper()
+	var domain *apperr.Error
+	if !errors.As(err, &domain) {
+		t.Fatalf("err = %v, want a domain error", err)
+	}
+	return domain.Fields
+}
+
+func TestDecodeJSON(t *testing.T) {
+	const valid = `"name":"bob","owner_id":1,"address":{"city":"Oslo"}`
+	tests := []struct {
+		name       string
+		json       string
+		wantFields []apperr.FieldError
+	}{
+		{"valid", `{` + valid + `}`, nil},
+		{"valid with optional fields", `{` + valid + `,"nickname":"bo","site":"https://example.com","other":{"city":"Bern"}}`, nil},
+		{"names match case-insensitively", `{"NAME":"bob","Owner_ID":1,"address":{"CITY":"Oslo"}}`, nil},
+		{
+			name: "every violation at once",
+			json: `{"name":" ","age":151,"nickname":"","site":"ftp://example.com","owner_id":11,"address":{"city":"Copenhagen"}}`,
+			wantFields: []apperr.FieldError{
+				{Field: "name", Message: "is required"},
+				{Field: "age", Message: "must be at most 150"},
+				{Field: "nickname", Message: "is required"},
+				{Field: "site", Message: "must be an absolute http or https URL"},
+				{Field: "owner_id", Message: "must be at most 10"},
+				{Field: "address.city", Message: "must be at most 5 characters"},
+			},
+		},
+		{
+			name:       "lengths count characters",
+			json:       `{` + valid + `,"nickname":"ééé","age":-1}`,
+			wantFields: []apperr.FieldError{{Field: "age", Message: "must be at least 0"}},
+		},
+		{
+			name:       "too short",
+			json:       `{"name":"b","owner_id":1,"address":{"city":"Oslo"}}`,
+			wantFields: []apperr.FieldError{{Field: "name", Message: "must be at least 2 characters"}},
+		},
+		{
+			name:       "relative URL",
+			json:       `{` + valid + `,"site":"/home"}`,
+			wantFields: []apperr.FieldError{{Field: "site", Message: "must be an absolute http or https URL"}},
+		},
+		{
+			name: "unknown and unencoded fields",
+			json: `{` + valid + `,"role":"admin","Secret":"x","internal":"y","address":{"city":"Oslo","zip":"0150"}}`,
+			wantFields: []apperr.FieldError{
+				{Field: "Secret", Message: "is not a known field"},
+				{Field: "address.zip", Message: "is not a known field"},
+				{Field: "internal", Message: "is not a known field"},
+				{Field: "role", Message: "is not a known field"},
+			},
+		},
+		{
+			// The rules of the field with the wrong type are not checked
+			name: "wrong type",
+			json: `{"name":7,"owner_id":1,"address":{"city":"Oslo"}}`,
+			wantFields: []apperr.FieldError{
+				{Field: "name", Message: "must be a string"},
+			},
+		},
+		{
+			name:       "missing required fields",
+			json:       `{}`,
+			wantFields: []apperr.FieldError{{Field: "name", Message: "is required"}, {Field: "owner_id", Message: "is required"}, {Field: "address.city", Message: "is required"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v body
+			err := validation.DecodeJSON(strings.NewReader(tt.json), &v)
+			if tt.wantFields == nil {
+				if err != nil {
+					t.Fatalf("DecodeJSON: %v", err)
+				}
+				return
+			}
+			if code := apperr.From(err).Code; code != apperr.CodeValidation {
+				t.Fatalf("code = %s, want %s (err %v)", code, apperr.CodeValidation, err)
+			}
+			if got := fieldsOf(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+				t.Errorf("fields = %+v\nwant %+v", got, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONInvalidBody(t *testing.T) {
+	for _, input := range []string{``, `{"name":`, `[1]x`, `{"name":"bob"} {}`} {
+		var v body
+		err := validation.DecodeJSON(strings.NewReader(input), &v)
+		if code := apperr.From(err).Code; code != apperr.CodeBadRequest {
+			t.Errorf("DecodeJSON(%q): code = %s, want %s", input, code, apperr.CodeBadRequest)
+		}
+	}
+
+	// Bodies cut off by http.MaxBytesReader are too large, not malformed
+	r := http.MaxBytesReader(nil, io.NopCloser(strings.NewReader(`{"name":"bob"}`)), 4)
+	var v body
+	err := validation.DecodeJSON(r, &v)
+	if code := apperr.From(err).Code; code != apperr.CodeRequestTooLarge {
+		t.Errorf("over the limit: code = %s, want %s", code, apperr.CodeRequestTooLarge)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	name := "toolong"
+	v := struct {
+		Name *string `json:"name" validate:"max=3"`
+		Age  *int    `json:"age" validate:"min=0"`
+	}{Name: &name}
+	err := validation.Validate(&v)
+	want := []apperr.FieldError{{Field: "name", Message: "must be at most 3 characters"}}
+	if got := fieldsOf(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %+v, want %+v", got, want)
+	}
+
+	if err := validation.Validate(42); err != nil {
+		t.Errorf("Validate(42) = %v", err)
+	}
+}